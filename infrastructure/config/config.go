@@ -1,32 +1,78 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
+	"os"
 
 	"github.com/BurntSushi/toml"
 
+	"prometheus-test/lib/gomonitor"
 	"prometheus-test/lib/logger"
+	prometheus "prometheus-test/lib/promethues"
 )
 
 var Cfg Config
 
+// Hash identifies the config file loaded by InitConfig, e.g. in crash
+// reports.
+var Hash string
+
 func InitConfig(filePath string) error {
-	if _, err := toml.DecodeFile(filePath, &Cfg); err != nil {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err := toml.Decode(string(data), &Cfg); err != nil {
 		return err
 	}
-	log.Printf("DisPatcher_Config=%+v", Cfg)
+	sum := sha256.Sum256(data)
+	Hash = hex.EncodeToString(sum[:8])
+	log.Printf("DisPatcher_Config=%+v", Cfg.redacted())
 
 	return nil
 }
 
 type Config struct {
+	Cluster    string                 `toml:"cluster"`
 	Log        logger.LoggerConf      `toml:"log"`
 	CommonConf CommonConfig           `toml:"common"`
 	ServerConf ServerConfig           `toml:"server"`
 	Mysql      map[string]MySqlConfig `toml:"mysql"`
+	Metrics    prometheus.Conf        `toml:"metrics"`
+	Profile    gomonitor.ProfileConf  `toml:"profile"`
+	Runtime    gomonitor.TuneConf     `toml:"runtime"`
+	Crash      gomonitor.CrashConf    `toml:"crash"`
+}
+
+// redacted is c without its secrets, for logging.
+func (c Config) redacted() Config {
+	if c.Log.DebugToken != "" {
+		c.Log.DebugToken = "REDACTED"
+	}
+	return c
+}
+
+// MetricsConf is the [metrics] section with the top-level cluster and the
+// common env added as static labels, unless [metrics.labels] sets them.
+func (c Config) MetricsConf() prometheus.Conf {
+	conf := c.Metrics
+	conf.Labels = make(map[string]string, len(c.Metrics.Labels)+2)
+	if c.Cluster != "" {
+		conf.Labels["cluster"] = c.Cluster
+	}
+	if c.CommonConf.Env != "" {
+		conf.Labels["env"] = c.CommonConf.Env
+	}
+	for k, v := range c.Metrics.Labels {
+		conf.Labels[k] = v
+	}
+	return conf
 }
 
 type CommonConfig struct {
+	// CrashLogPath receives stderr, where the runtime writes crashes.
 	CrashLogPath string `toml:"crash_log_path"`
 	Env          string `toml:"env"`
 	ServerName   string `toml:"server_name"`
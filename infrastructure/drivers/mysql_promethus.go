@@ -53,7 +53,7 @@ func (m *MysqlPrometheus) AfterCallback() func(db *gorm.DB) {
 		err := db.Error
 
 		timeCost := time.Now().UnixMilli() - begin.(int64)
-		metrics.UpdateDB(db.Statement.Table, cmd.(string), timeCost, err)
+		metrics.UpdateDB(db.Statement.Context, db.Statement.Table, cmd.(string), timeCost, err)
 		metrics.UpdateDBQPS(db.Statement.Table, cmd.(string), err, 1)
 
 	}
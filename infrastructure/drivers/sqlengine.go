@@ -5,9 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"prometheus-test/infrastructure/config"
 	"time"
 
+	bizlogger "prometheus-test/lib/logger"
+
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -44,6 +47,19 @@ func InitMysql() error {
 	return nil
 }
 
+// sqlLogger is GORM's default logger, with the values in the SQL it
+// prints redacted. Redaction only sees "name = value", so with it on the
+// SQL keeps its placeholders, the values of an INSERT list being apart
+// from their columns.
+func sqlLogger(level int) logger.Interface {
+	return logger.New(log.New(bizlogger.RedactWriter(os.Stdout), "\r\n", log.LstdFlags), logger.Config{
+		SlowThreshold:        200 * time.Millisecond,
+		LogLevel:             logger.LogLevel(level),
+		Colorful:             true,
+		ParameterizedQueries: bizlogger.Redacting(),
+	})
+}
+
 func createMysqlEngine(conf config.MySqlConfig) (*gorm.DB, error) {
 	//here can use xorm.EngineGroup  for slave db.
 	dsn := fmt.Sprintf("%s:%s@%s(%s:%d)/%s?charset=utf8mb4&timeout=%s&readTimeout=%s&writeTimeout=%s&parseTime=true",
@@ -56,7 +72,7 @@ func createMysqlEngine(conf config.MySqlConfig) (*gorm.DB, error) {
 
 	engine, err := gorm.Open(mysql.New(mysql.Config{
 		DSN: dsn,
-	}), &gorm.Config{Logger: logger.Default.LogMode(logger.LogLevel(conf.LogLevel))})
+	}), &gorm.Config{Logger: sqlLogger(conf.LogLevel)})
 
 	if err = engine.Use(dbresolver.Register(dbresolver.Config{
 		Sources:  []gorm.Dialector{mysql.Open(dsn)},
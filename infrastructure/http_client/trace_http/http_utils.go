@@ -80,13 +80,13 @@ func (h *Client) DeserializeResult(r any) {
 	}
 }
 
-func (h *Client) prometheusMetrics(url string, start time.Time, err *error) {
+func (h *Client) prometheusMetrics(ctx context.Context, url string, start time.Time, err *error) {
 	path := url
 	uri, errPro := neturl.Parse(url)
 	if errPro == nil {
 		path = uri.Path
 	}
-	metrics.UpdateDependence("all", path, time.Since(start).Milliseconds(), *err)
+	metrics.UpdateDependence(ctx, "all", path, time.Since(start).Milliseconds(), *err)
 	metrics.UpdateDependenceQPS("all", path, h.RawResponse.StatusCode(), 1)
 }
 
@@ -95,7 +95,7 @@ func (h *Client) GET(ctx context.Context, url string) *Client {
 
 	elapsed := time.Now()
 	var err error
-	defer h.prometheusMetrics(url, elapsed, &err)
+	defer h.prometheusMetrics(ctx, url, elapsed, &err)
 	resp, err := h.request.Get(url)
 	h.HandleResponse(ctx, err, url, resp)
 	if h.LogResult {
@@ -108,7 +108,7 @@ func (h *Client) POST(ctx context.Context, url string, body []byte) *Client {
 	h.WithQueryParam("request_id", util.GetRequestId(ctx))
 	elapsed := time.Now()
 	var err error
-	defer h.prometheusMetrics(url, elapsed, &err)
+	defer h.prometheusMetrics(ctx, url, elapsed, &err)
 
 	resp, err := h.request.SetBody(body).Post(url)
 	h.HandleResponse(ctx, err, url, resp)
@@ -122,7 +122,7 @@ func (h *Client) PUT(ctx context.Context, url string, body []byte) *Client {
 	h.WithQueryParam("request_id", util.GetRequestId(ctx))
 	elapsed := time.Now()
 	var err error
-	defer h.prometheusMetrics(url, elapsed, &err)
+	defer h.prometheusMetrics(ctx, url, elapsed, &err)
 	resp, err := h.request.SetBody(body).Put(url)
 	h.HandleResponse(ctx, err, url, resp)
 	if h.LogResult {
@@ -145,12 +145,12 @@ func (h *Client) Log(logResult bool) *Client {
 func (h *Client) HandleResponse(ctx context.Context,
 	err error, url string, resp *resty.Response) *Client {
 	if err != nil {
-		print(fmt.Sprintf("Fail to access: %s cause: %s", url, err))
+		print(logger.Redact(fmt.Sprintf("Fail to access: %s cause: %s", url, err)))
 		h.Result = EmptyByteArr
 		h.Err = err
 	}
 	if resp == nil {
-		print(fmt.Sprintf("Empty response while access: %s", url))
+		print(logger.Redact(fmt.Sprintf("Empty response while access: %s", url)))
 		h.Result = EmptyByteArr
 		h.Err = err
 	}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	prometheus "prometheus-test/lib/promethues"
+)
+
+// Generate writes the Prometheus rules and the Grafana dashboard of
+// Definitions to dir, as "<srvName>.rules.yml" and
+// "<srvName>.dashboard.json". With check it writes nothing and fails if
+// the files in dir differ, so CI can catch definitions or SLOs changed
+// without regenerating.
+func Generate(dir string, srvName string, conf prometheus.Conf, check bool) error {
+	rules, err := conf.Rules(srvName, Definitions)
+	if err != nil {
+		return err
+	}
+	dashboard, err := conf.Dashboard(srvName, Definitions)
+	if err != nil {
+		return err
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{srvName + ".rules.yml", rules},
+		{srvName + ".dashboard.json", append(dashboard, '\n')},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if !check {
+			if err := os.WriteFile(path, f.data, 0644); err != nil {
+				return err
+			}
+			continue
+		}
+		old, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(old, f.data) {
+			return fmt.Errorf("%s is out of date, regenerate it", path)
+		}
+	}
+	return nil
+}
@@ -1,161 +1,112 @@
 package metrics
 
+//go:generate go run prometheus-test/lib/promethues/metricsgen -in definitions.toml -out metrics_gen.go -pkg metrics
+
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"time"
 
+	"prometheus-test/infrastructure/recycle"
 	"prometheus-test/lib/gomonitor"
 	"prometheus-test/lib/logger"
 	prometheus "prometheus-test/lib/promethues"
 	"prometheus-test/lib/util"
 )
 
-const (
-	MonitorNameInterface          = "interface"
-	MonitorNameInterfaceQps       = "interface_qps"
-	MonitorNameInterfaceErrorCode = "interface_code"
-	MonitorNameDependence         = "dependence"
-	MonitorNameDependenceQps      = "dependence_qps"
-	MonitorNameStatistics         = "statistics"
-
-	MonitorNameDb    = "DB"
-	MonitorNameDbQps = "DB_qps"
-)
-
-func Init(srvName string) error {
-	prometheus.Init(srvName, util.IdcName())
-	register()
+func Init(srvName string, conf prometheus.Conf) error {
+	if err := prometheus.Init(srvName, util.IdcName(), conf); err != nil {
+		return err
+	}
+	if err := gomonitor.Register(prometheus.Default().Registerer()); err != nil {
+		return err
+	}
+	if err := logger.Register(prometheus.Default().Registerer()); err != nil {
+		return err
+	}
+	RegisterDefinitions(prometheus.Default())
+	recycle.RegisterRecycles(prometheus.Default().Close)
+	if conf.Push.Enable {
+		pusher, err := prometheus.Default().NewPusher(conf.Push)
+		if err != nil {
+			return err
+		}
+		pusher.Start()
+		recycle.RegisterRecycles(pusher.Stop)
+	}
+	if conf.SinkEnabled(prometheus.SinkStatsD) {
+		sink, err := prometheus.Default().NewStatsDSink(conf.StatsD)
+		if err != nil {
+			return err
+		}
+		prometheus.Default().AddSink(sink)
+		recycle.RegisterRecycles(sink.Close)
+	}
 	go monitor()
 	return nil
 
 }
 
-func register() {
-	prometheus.Registe(prometheus.TypeSummary, MonitorNameInterface, []string{"interface", "status"}, nil)
-	prometheus.Registe(prometheus.TypeQPS, MonitorNameInterfaceQps, []string{"interface", "status"}, nil)
-	prometheus.Registe(prometheus.TypeQPS, MonitorNameInterfaceErrorCode, []string{"interface", "status"}, nil)
-
-	prometheus.Registe(prometheus.TypeSummary, MonitorNameDependence, []string{"dependence_service", "function", "status"}, nil)
-	prometheus.Registe(prometheus.TypeQPS, MonitorNameDependenceQps, []string{"dependence_service", "function", "status"}, nil)
-
-	prometheus.Registe(prometheus.TypeTotal, MonitorNameStatistics, []string{"module"}, nil)
-
-	prometheus.Registe(prometheus.TypeSummary, MonitorNameDb, []string{"table", "function", "status"}, nil)
-	prometheus.Registe(prometheus.TypeQPS, MonitorNameDbQps, []string{"table", "function", "status"}, nil)
-}
-
 func monitor() {
 
 	var interval int64 = 10
 	for {
 		stat := gomonitor.GetState()
 		logger.NotCtxInfo("[Monitor]", "MEMStat", util.StructToJson(stat))
-		UpdateStatistics("GO_GCNum", int64(stat.GCNum))
-		UpdateStatistics("GO_GCPause", int64(stat.GCPause))
-		UpdateStatistics("GO_MemStack", int64(stat.MemStack))
-		UpdateStatistics("GO_MemMallocs", int64(stat.MemMallocs))
-		UpdateStatistics("GO_MemAllocated", int64(stat.MemAllocated))
-		UpdateStatistics("GO_MemObjects", int64(stat.MemObjects))
-		UpdateStatistics("GO_MemHeap", int64(stat.MemHeap))
-		UpdateStatistics("GO_GoroutineNum", int64(stat.GoroutineNum))
 		time.Sleep(time.Duration(interval) * time.Second)
 	}
 }
-func UpdateInterface(method string, status int, value int64) {
-	labels := map[string]string{
-		"interface": method,
-		"status":    fmt.Sprintf("%v", status),
-	}
-	err := prometheus.Update(prometheus.TypeSummary, MonitorNameInterface, labels, float64(value))
-	if err != nil {
-		logger.NotCtxInfof("prometheus.Update UpdateInterface failed,err=%v", err)
-	}
+
+func UpdateInterface(ctx context.Context, method string, status int, value int64) {
+	logUpdate(ctx, "interface", Interface.Observe(ctx, method, strconv.Itoa(status), float64(value)))
 }
 
 func UpdateInterfaceQPS(method string, status int, value int64) {
-	labels := map[string]string{
-		"interface": method,
-		"status":    fmt.Sprintf("%v", status),
-	}
-	err := prometheus.Update(prometheus.TypeQPS, MonitorNameInterfaceQps, labels, float64(value))
-	if err != nil {
-		logger.NotCtxInfof("prometheus.Update UpdateInterfaceQPS failed,err=%v", err)
-	}
+	logUpdateNotCtx("interface_qps", InterfaceQps.Add(method, strconv.Itoa(status), float64(value)))
 }
 
 func UpdateInterfaceQPSByErrorCode(method string, status int, value int64) {
-	labels := map[string]string{
-		"interface": method,
-		"status":    fmt.Sprintf("%v", status),
-	}
-	err := prometheus.Update(prometheus.TypeQPS, MonitorNameInterfaceErrorCode, labels, float64(value))
-	if err != nil {
-		logger.NotCtxInfof("prometheus.Update UpdateInterfaceQPSByErrorCode failed,err=%v", err)
-	}
+	logUpdateNotCtx("interface_code", InterfaceCode.Add(method, strconv.Itoa(status), float64(value)))
 }
 
-func UpdateDependence(service, function string, value int64, err error) {
-	labels := map[string]string{
-		"function":           function,
-		"dependence_service": service,
-	}
-	if err != nil {
-		labels["status"] = "1"
-	} else {
-		labels["status"] = "0"
-	}
-	err2 := prometheus.Update(prometheus.TypeSummary, MonitorNameDependence, labels, float64(value))
-	if err2 != nil {
-		logger.NotCtxInfof("prometheus.Update UpdateDependence failed,err=%v", err2)
-	}
+func UpdateDependence(ctx context.Context, service, function string, value int64, err error) {
+	logUpdate(ctx, "dependence", Dependence.Observe(ctx, service, function, errStatus(err), float64(value)))
 }
 
 func UpdateDependenceQPS(service, method string, status int, value int64) {
-	labels := map[string]string{
-		"function":           method,
-		"dependence_service": service,
-		"status":             fmt.Sprintf("%v", status),
-	}
-	err := prometheus.Update(prometheus.TypeQPS, MonitorNameDependenceQps, labels, float64(value))
-	if err != nil {
-		logger.NotCtxInfof("prometheus.Update UpdateDependenceQPS failed,err=%v", err)
-	}
+	logUpdateNotCtx("dependence_qps", DependenceQps.Add(service, method, strconv.Itoa(status), float64(value)))
 }
 
 func UpdateStatistics(module string, value int64) {
-	labels := map[string]string{
-		"module": module,
-	}
-	err := prometheus.Update(prometheus.TypeTotal, MonitorNameStatistics, labels, float64(value))
+	logUpdateNotCtx("statistics", Statistics.Observe(context.Background(), module, float64(value)))
+}
+
+func UpdateDB(ctx context.Context, table, method string, value int64, err error) {
+	logUpdate(ctx, "DB", DB.Observe(ctx, table, method, errStatus(err), float64(value)))
+}
+
+func UpdateDBQPS(table, method string, status error, value int64) {
+	logUpdateNotCtx("DB_qps", DBQps.Add(table, method, fmt.Sprintf("%v", status == nil), float64(value)))
+}
+
+// logUpdate logs a refused metric update. The logger samples repeats, so
+// a metric refused on every request doesn't flood the log.
+func logUpdate(ctx context.Context, name string, err error) {
 	if err != nil {
-		logger.NotCtxInfof("prometheus.Update UpdateStatistics failed,err=%v,%+v,%+v", err, module, value)
+		logger.Error(ctx, "[Metrics] update refused", "metric", name, "err", err.Error())
 	}
 }
 
-func UpdateDB(table, method string, value int64, err error) {
-	labels := map[string]string{
-		"function": method,
-		"table":    table,
-	}
+func logUpdateNotCtx(name string, err error) {
 	if err != nil {
-		labels["status"] = "1"
-	} else {
-		labels["status"] = "0"
-	}
-	err2 := prometheus.Update(prometheus.TypeSummary, MonitorNameDb, labels, float64(value))
-	if err2 != nil {
-		logger.NotCtxInfof("prometheus.Update UpdateDB failed,err=%v", err2)
+		logger.NotCtxError("[Metrics] update refused", "metric", name, "err", err.Error())
 	}
 }
 
-func UpdateDBQPS(table, method string, status error, value int64) {
-	labels := map[string]string{
-		"function": method,
-		"table":    table,
-		"status":   fmt.Sprintf("%v", status == nil),
-	}
-	err := prometheus.Update(prometheus.TypeQPS, MonitorNameDbQps, labels, float64(value))
+func errStatus(err error) string {
 	if err != nil {
-		logger.NotCtxInfof("prometheus.Update UpdateDBQPS failed,err=%v", err)
+		return "1"
 	}
+	return "0"
 }
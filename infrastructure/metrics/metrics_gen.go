@@ -0,0 +1,174 @@
+// Code generated by metricsgen from definitions.toml. DO NOT EDIT.
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	prometheus "prometheus-test/lib/promethues"
+)
+
+// Definitions lists every metric declared in definitions.toml.
+var Definitions = []prometheus.Definition{
+	{
+		Name:   "interface",
+		Type:   prometheus.TypeTotal,
+		Help:   "Latency of served HTTP requests in milliseconds.",
+		Labels: []string{"interface", "status"},
+		Unit:   "ms",
+	},
+	{
+		Name:   "interface_qps",
+		Type:   prometheus.TypeQPS,
+		Help:   "Served HTTP requests.",
+		Labels: []string{"interface", "status"},
+	},
+	{
+		Name:   "interface_code",
+		Type:   prometheus.TypeQPS,
+		Help:   "Served HTTP requests by business error code.",
+		Labels: []string{"interface", "status"},
+	},
+	{
+		Name:   "dependence",
+		Type:   prometheus.TypeTotal,
+		Help:   "Latency of calls to downstream services in milliseconds.",
+		Labels: []string{"dependence_service", "function", "status"},
+		Unit:   "ms",
+	},
+	{
+		Name:   "dependence_qps",
+		Type:   prometheus.TypeQPS,
+		Help:   "Calls to downstream services.",
+		Labels: []string{"dependence_service", "function", "status"},
+	},
+	{
+		Name:   "statistics",
+		Type:   prometheus.TypeTotal,
+		Help:   "Free-form business statistics by module.",
+		Labels: []string{"module"},
+	},
+	{
+		Name:   "DB",
+		Type:   prometheus.TypeTotal,
+		Help:   "Latency of database operations in milliseconds.",
+		Labels: []string{"table", "function", "status"},
+		Unit:   "ms",
+	},
+	{
+		Name:   "DB_qps",
+		Type:   prometheus.TypeQPS,
+		Help:   "Database operations.",
+		Labels: []string{"table", "function", "status"},
+	},
+}
+
+var (
+	Interface     InterfaceMetric
+	InterfaceQps  InterfaceQpsMetric
+	InterfaceCode InterfaceCodeMetric
+	Dependence    DependenceMetric
+	DependenceQps DependenceQpsMetric
+	Statistics    StatisticsMetric
+	DB            DBMetric
+	DBQps         DBQpsMetric
+)
+
+// RegisterDefinitions registers every definition with r and points the
+// typed handles at it. It panics if a name is already taken by a metric
+// with another type or other labels.
+func RegisterDefinitions(r *prometheus.Registry) {
+	Interface = InterfaceMetric{r.MustRegisterDefinition(Definitions[0])}
+	InterfaceQps = InterfaceQpsMetric{r.MustRegisterDefinition(Definitions[1])}
+	InterfaceCode = InterfaceCodeMetric{r.MustRegisterDefinition(Definitions[2])}
+	Dependence = DependenceMetric{r.MustRegisterDefinition(Definitions[3])}
+	DependenceQps = DependenceQpsMetric{r.MustRegisterDefinition(Definitions[4])}
+	Statistics = StatisticsMetric{r.MustRegisterDefinition(Definitions[5])}
+	DB = DBMetric{r.MustRegisterDefinition(Definitions[6])}
+	DBQps = DBQpsMetric{r.MustRegisterDefinition(Definitions[7])}
+}
+
+// InterfaceMetric is the typed handle of "interface": Latency of served HTTP requests in milliseconds.
+type InterfaceMetric struct{ h *prometheus.Handle }
+
+func (m InterfaceMetric) Observe(ctx context.Context, interfaceValue, status string, v float64) error {
+	return m.h.Observe(ctx, v, interfaceValue, status)
+}
+
+func (m InterfaceMetric) ObserveDuration(ctx context.Context, interfaceValue, status string, d time.Duration) error {
+	return m.h.Observe(ctx, float64(d)/float64(time.Millisecond), interfaceValue, status)
+}
+
+// InterfaceQpsMetric is the typed handle of "interface_qps": Served HTTP requests.
+type InterfaceQpsMetric struct{ h *prometheus.Handle }
+
+func (m InterfaceQpsMetric) Inc(interfaceValue, status string) error {
+	return m.h.Add(1, interfaceValue, status)
+}
+
+func (m InterfaceQpsMetric) Add(interfaceValue, status string, v float64) error {
+	return m.h.Add(v, interfaceValue, status)
+}
+
+// InterfaceCodeMetric is the typed handle of "interface_code": Served HTTP requests by business error code.
+type InterfaceCodeMetric struct{ h *prometheus.Handle }
+
+func (m InterfaceCodeMetric) Inc(interfaceValue, status string) error {
+	return m.h.Add(1, interfaceValue, status)
+}
+
+func (m InterfaceCodeMetric) Add(interfaceValue, status string, v float64) error {
+	return m.h.Add(v, interfaceValue, status)
+}
+
+// DependenceMetric is the typed handle of "dependence": Latency of calls to downstream services in milliseconds.
+type DependenceMetric struct{ h *prometheus.Handle }
+
+func (m DependenceMetric) Observe(ctx context.Context, dependenceService, function, status string, v float64) error {
+	return m.h.Observe(ctx, v, dependenceService, function, status)
+}
+
+func (m DependenceMetric) ObserveDuration(ctx context.Context, dependenceService, function, status string, d time.Duration) error {
+	return m.h.Observe(ctx, float64(d)/float64(time.Millisecond), dependenceService, function, status)
+}
+
+// DependenceQpsMetric is the typed handle of "dependence_qps": Calls to downstream services.
+type DependenceQpsMetric struct{ h *prometheus.Handle }
+
+func (m DependenceQpsMetric) Inc(dependenceService, function, status string) error {
+	return m.h.Add(1, dependenceService, function, status)
+}
+
+func (m DependenceQpsMetric) Add(dependenceService, function, status string, v float64) error {
+	return m.h.Add(v, dependenceService, function, status)
+}
+
+// StatisticsMetric is the typed handle of "statistics": Free-form business statistics by module.
+type StatisticsMetric struct{ h *prometheus.Handle }
+
+func (m StatisticsMetric) Observe(ctx context.Context, module string, v float64) error {
+	return m.h.Observe(ctx, v, module)
+}
+
+// DBMetric is the typed handle of "DB": Latency of database operations in milliseconds.
+type DBMetric struct{ h *prometheus.Handle }
+
+func (m DBMetric) Observe(ctx context.Context, table, function, status string, v float64) error {
+	return m.h.Observe(ctx, v, table, function, status)
+}
+
+func (m DBMetric) ObserveDuration(ctx context.Context, table, function, status string, d time.Duration) error {
+	return m.h.Observe(ctx, float64(d)/float64(time.Millisecond), table, function, status)
+}
+
+// DBQpsMetric is the typed handle of "DB_qps": Database operations.
+type DBQpsMetric struct{ h *prometheus.Handle }
+
+func (m DBQpsMetric) Inc(table, function, status string) error {
+	return m.h.Add(1, table, function, status)
+}
+
+func (m DBQpsMetric) Add(table, function, status string, v float64) error {
+	return m.h.Add(v, table, function, status)
+}
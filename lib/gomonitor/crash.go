@@ -0,0 +1,393 @@
+package gomonitor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// CrashFatal is a crash of the previous run found in its stderr.
+	CrashFatal = "fatal"
+	// CrashPanic is a panic that ends the process, reported by main.
+	CrashPanic = "panic"
+	// CrashRecovered is a panic recovered by the process, e.g. in a
+	// request.
+	CrashRecovered = "recovered"
+)
+
+// CrashConf is the [crash] section. A report is written into Dir for
+// every crash, recovered panics are rate limited.
+type CrashConf struct {
+	Dir string `toml:"dir"`
+	// MaxReports and MaxAge bound the reports kept in Dir.
+	MaxReports int    `toml:"max_reports"`
+	MaxAge     string `toml:"max_age"`
+	// RecoveredInterval is the least time between two reports of
+	// recovered panics, "1m" by default. Those in between are counted.
+	RecoveredInterval string `toml:"recovered_interval"`
+}
+
+// crashTimeLayout names reports so they sort by time.
+const crashTimeLayout = "20060102-150405.000"
+
+// crashHeader starts the stderr file, so the next run knows when and
+// what crashed.
+const crashHeader = "# crash-header"
+
+// crashLine starts what the runtime writes when the process crashes.
+var crashLine = regexp.MustCompile(`(?m)^(panic|fatal error): `)
+
+// lastRequestsFile keeps the last request ids for the next run.
+const lastRequestsFile = "last_requests"
+
+var crashes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "process_crashes_total",
+	Help: "Crash reports by kind, the fatal and panic ones of the previous run are counted at start.",
+}, []string{"kind"})
+
+// CrashReporter writes a report per crash. The runtime writes fatal
+// crashes to stderr, which Start sends to a file turned into a report by
+// the next run.
+type CrashReporter struct {
+	dir               string
+	maxReports        int
+	maxAge            time.Duration
+	recoveredInterval time.Duration
+	stderrPath        string
+	configHash        string
+	started           time.Time
+
+	mutex    sync.Mutex
+	requests [32]string
+	next     int
+	changed  bool
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// lastRecovered is when a recovered panic was last reported,
+	// skippedRecovered how many were not since. Both are guarded by mutex.
+	lastRecovered    time.Time
+	skippedRecovered int
+}
+
+var defaultCrashReporter *CrashReporter
+
+// NewCrashReporter checks conf and creates its Dir. stderrPath receives
+// stderr, configHash identifies the config in the reports.
+func NewCrashReporter(conf CrashConf, stderrPath string, configHash string) (*CrashReporter, error) {
+	r := &CrashReporter{
+		dir:               conf.Dir,
+		maxReports:        conf.MaxReports,
+		maxAge:            30 * 24 * time.Hour,
+		recoveredInterval: time.Minute,
+		stderrPath:        stderrPath,
+		configHash:        configHash,
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+	if r.dir == "" {
+		r.dir = "./logs/crash"
+	}
+	if r.maxReports == 0 {
+		r.maxReports = 20
+	}
+	if conf.MaxAge != "" {
+		d, err := time.ParseDuration(conf.MaxAge)
+		if err != nil {
+			return nil, err
+		}
+		r.maxAge = d
+	}
+	if conf.RecoveredInterval != "" {
+		d, err := time.ParseDuration(conf.RecoveredInterval)
+		if err != nil {
+			return nil, err
+		}
+		r.recoveredInterval = d
+	}
+	if stderrPath == "" {
+		return nil, fmt.Errorf("no crash log path")
+	}
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// InitCrashReporter creates the CrashReporter returned by
+// DefaultCrashReporter and starts it.
+func InitCrashReporter(conf CrashConf, stderrPath string, configHash string) (*CrashReporter, error) {
+	r, err := NewCrashReporter(conf, stderrPath, configHash)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Start(); err != nil {
+		return nil, err
+	}
+	defaultCrashReporter = r
+	return r, nil
+}
+
+// DefaultCrashReporter returns the CrashReporter created by
+// InitCrashReporter, or nil.
+func DefaultCrashReporter() *CrashReporter {
+	return defaultCrashReporter
+}
+
+// Start reports the crash of the previous run, sends stderr to a fresh
+// stderr file and keeps the last request ids on disk.
+func (r *CrashReporter) Start() error {
+	r.started = time.Now()
+	r.harvest()
+	f, err := os.OpenFile(r.stderrPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, "%s started=%s pid=%d config_hash=%s revision=%s\n",
+		crashHeader, r.started.Format(time.RFC3339Nano), os.Getpid(), r.configHash, buildSetting("vcs.revision"))
+	if err := syscall.Dup2(int(f.Fd()), 2); err != nil {
+		f.Close()
+		return err
+	}
+	// Fatal crashes dump every goroutine, not only the crashing one.
+	debug.SetTraceback("all")
+	go r.run()
+	return nil
+}
+
+// Stop writes the last request ids out. It matches
+// recycle.ResourceRecyclable.
+func (r *CrashReporter) Stop() bool {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+	return true
+}
+
+func (r *CrashReporter) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.saveRequests()
+		case <-r.stop:
+			r.saveRequests()
+			return
+		}
+	}
+}
+
+// Track records requestId as one of the last requests.
+func (r *CrashReporter) Track(requestId string) {
+	r.mutex.Lock()
+	r.requests[r.next] = requestId
+	r.next = (r.next + 1) % len(r.requests)
+	r.changed = true
+	r.mutex.Unlock()
+}
+
+// lastRequests returns the tracked request ids, oldest first.
+func (r *CrashReporter) lastRequests() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ids := make([]string, 0, len(r.requests))
+	for i := range r.requests {
+		if id := r.requests[(r.next+i)%len(r.requests)]; id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (r *CrashReporter) saveRequests() {
+	r.mutex.Lock()
+	changed := r.changed
+	r.changed = false
+	r.mutex.Unlock()
+	if !changed {
+		return
+	}
+	path := filepath.Join(r.dir, lastRequestsFile)
+	if err := os.WriteFile(path+".tmp", []byte(strings.Join(r.lastRequests(), "\n")), 0644); err == nil {
+		_ = os.Rename(path+".tmp", path)
+	}
+}
+
+// Report writes a report of the panic p with its stack, the build, the
+// config hash and the last request ids, and returns its path. The report
+// of a CrashPanic also dumps every goroutine. A recovered panic within
+// RecoveredInterval of the last reported one is only counted, and ""
+// returned, so a panicking request can't flood Dir.
+func (r *CrashReporter) Report(kind string, p interface{}, stack []byte, requestId string) (string, error) {
+	now := time.Now()
+	skipped := 0
+	if kind == CrashRecovered {
+		crashes.WithLabelValues(kind).Inc()
+		r.mutex.Lock()
+		if !r.lastRecovered.IsZero() && now.Sub(r.lastRecovered) < r.recoveredInterval {
+			r.skippedRecovered++
+			r.mutex.Unlock()
+			return "", nil
+		}
+		r.lastRecovered = now
+		skipped, r.skippedRecovered = r.skippedRecovered, 0
+		r.mutex.Unlock()
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "time: %s\nkind: %s\npanic: %v\nrequest_id: %s\n", now.Format(time.RFC3339Nano), kind, p, requestId)
+	if skipped > 0 {
+		fmt.Fprintf(&b, "unreported_since_last: %d\n", skipped)
+	}
+	fmt.Fprintf(&b, "started: %s\npid: %d\nconfig_hash: %s\n", r.started.Format(time.RFC3339Nano), os.Getpid(), r.configHash)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(&b, "go: %s\nmodule: %s %s\n", info.GoVersion, info.Main.Path, info.Main.Version)
+		for _, s := range info.Settings {
+			if strings.HasPrefix(s.Key, "vcs.") {
+				fmt.Fprintf(&b, "%s: %s\n", s.Key, s.Value)
+			}
+		}
+	}
+	fmt.Fprintf(&b, "last_requests: %s\n", strings.Join(r.lastRequests(), ","))
+	fmt.Fprintf(&b, "\nstack:\n%s\n", stack)
+	if kind == CrashPanic {
+		b.WriteString("\ngoroutines:\n")
+		_ = pprof.Lookup("goroutine").WriteTo(&b, 2)
+	}
+	return r.write(now, kind, b.Bytes())
+}
+
+func (r *CrashReporter) write(at time.Time, kind string, report []byte) (string, error) {
+	path := filepath.Join(r.dir, at.Format(crashTimeLayout)+"-"+kind+".txt")
+	if err := os.WriteFile(path, report, 0644); err != nil {
+		return "", err
+	}
+	r.prune(time.Now())
+	return path, nil
+}
+
+// harvest reports a crash found in the stderr file of the previous run,
+// and counts it once: as the panic main reported since that run started,
+// or else as a fatal crash.
+func (r *CrashReporter) harvest() {
+	data, err := os.ReadFile(r.stderrPath)
+	if err != nil {
+		return
+	}
+	header := parseCrashHeader(data)
+	panicked := false
+	if s, ok := header["started"]; ok {
+		if prevStarted, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			reports, _ := r.List()
+			for _, report := range reports {
+				if report.Kind == CrashPanic && report.Time.After(prevStarted) {
+					crashes.WithLabelValues(CrashPanic).Inc()
+					panicked = true
+				}
+			}
+		}
+	}
+	if !crashLine.Match(data) {
+		return
+	}
+	at := r.started
+	if fi, err := os.Stat(r.stderrPath); err == nil {
+		at = fi.ModTime()
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "time: %s\nkind: %s\n", at.Format(time.RFC3339Nano), CrashFatal)
+	fmt.Fprintf(&b, "started: %s\npid: %s\nconfig_hash: %s\nvcs.revision: %s\n",
+		header["started"], header["pid"], header["config_hash"], header["revision"])
+	requests, _ := os.ReadFile(filepath.Join(r.dir, lastRequestsFile))
+	fmt.Fprintf(&b, "last_requests: %s\n\nstderr:\n", bytes.ReplaceAll(bytes.TrimSpace(requests), []byte("\n"), []byte(",")))
+	b.Write(data)
+	// The runtime also writes the panic main reported, whose report
+	// counted it already.
+	if _, err := r.write(at, CrashFatal, b.Bytes()); err == nil && !panicked {
+		crashes.WithLabelValues(CrashFatal).Inc()
+	}
+}
+
+// parseCrashHeader returns the key=value pairs of the header line.
+func parseCrashHeader(data []byte) map[string]string {
+	header := make(map[string]string)
+	line, _, _ := bufio.NewReader(bytes.NewReader(data)).ReadLine()
+	if !bytes.HasPrefix(line, []byte(crashHeader)) {
+		return header
+	}
+	for _, field := range strings.Fields(string(line[len(crashHeader):])) {
+		if k, v, ok := strings.Cut(field, "="); ok {
+			header[k] = v
+		}
+	}
+	return header
+}
+
+// CrashReport is a report file in Dir.
+type CrashReport struct {
+	Name string    `json:"name"`
+	Kind string    `json:"kind"`
+	Time time.Time `json:"time"`
+}
+
+// List returns the reports in Dir, newest first.
+func (r *CrashReporter) List() ([]CrashReport, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	var reports []CrashReport
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".txt")
+		if e.IsDir() || name == e.Name() || len(name) <= len(crashTimeLayout) {
+			continue
+		}
+		at, err := time.ParseInLocation(crashTimeLayout, name[:len(crashTimeLayout)], time.Local)
+		if err != nil {
+			continue
+		}
+		reports = append(reports, CrashReport{Name: e.Name(), Kind: name[len(crashTimeLayout)+1:], Time: at})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Time.After(reports[j].Time) })
+	return reports, nil
+}
+
+func (r *CrashReporter) prune(now time.Time) {
+	reports, err := r.List()
+	if err != nil {
+		return
+	}
+	for i, report := range reports {
+		if i >= r.maxReports || now.Sub(report.Time) > r.maxAge {
+			_ = os.Remove(filepath.Join(r.dir, report.Name))
+		}
+	}
+}
+
+// buildSetting returns the build setting key, e.g. "vcs.revision", or "".
+func buildSetting(key string) string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == key {
+				return s.Value
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package gomonitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReportRateLimitsRecovered(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewCrashReporter(CrashConf{Dir: dir, RecoveredInterval: "1h"}, filepath.Join(dir, "stderr.log"), "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := r.Report(CrashRecovered, "boom", []byte("stack"), "req-1")
+	if err != nil || first == "" {
+		t.Fatalf("first recovered panic: path=%q err=%v", first, err)
+	}
+	for i := 0; i < 3; i++ {
+		if path, err := r.Report(CrashRecovered, "boom", []byte("stack"), "req-2"); path != "" || err != nil {
+			t.Fatalf("recovered panic within the interval: path=%q err=%v", path, err)
+		}
+	}
+	report, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(report), "goroutines:") {
+		t.Error("recovered panic report dumps the goroutines")
+	}
+
+	r.lastRecovered = r.lastRecovered.Add(-2 * r.recoveredInterval)
+	next, err := r.Report(CrashRecovered, "boom", []byte("stack"), "req-3")
+	if err != nil || next == "" {
+		t.Fatalf("recovered panic after the interval: path=%q err=%v", next, err)
+	}
+	if report, _ := os.ReadFile(next); !strings.Contains(string(report), "unreported_since_last: 3\n") {
+		t.Errorf("report doesn't count the skipped panics:\n%s", report)
+	}
+
+	fatal, err := r.Report(CrashPanic, "boom", []byte("stack"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, _ := os.ReadFile(fatal); !strings.Contains(string(report), "goroutines:") {
+		t.Error("panic report lacks the goroutines")
+	}
+}
+
+func TestHarvestCountsCrashOnce(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		stderr       string
+		panicReport  bool
+		wantReports  int
+		fatal, panic float64
+	}{
+		{"clean exit", "", false, 0, 0, 0},
+		{"fatal error", "fatal error: concurrent map writes\n", false, 1, 1, 0},
+		{"unreported panic", "panic: boom\n\ngoroutine 1 [running]:\n", false, 1, 1, 0},
+		{"reported panic", "panic: boom\n\ngoroutine 1 [running]:\n", true, 2, 0, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			stderrPath := filepath.Join(dir, "stderr.log")
+			r, err := NewCrashReporter(CrashConf{Dir: filepath.Join(dir, "crash")}, stderrPath, "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			started := time.Now().Add(-time.Hour)
+			stderr := fmt.Sprintf("%s started=%s pid=42 config_hash=old revision=abc\n%s",
+				crashHeader, started.Format(time.RFC3339Nano), tt.stderr)
+			if err := os.WriteFile(stderrPath, []byte(stderr), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.panicReport {
+				if _, err := r.Report(CrashPanic, "boom", []byte("stack"), ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fatal, panicked := testutil.ToFloat64(crashes.WithLabelValues(CrashFatal)), testutil.ToFloat64(crashes.WithLabelValues(CrashPanic))
+
+			r.started = time.Now()
+			r.harvest()
+
+			if got := testutil.ToFloat64(crashes.WithLabelValues(CrashFatal)) - fatal; got != tt.fatal {
+				t.Errorf("counted %v fatal crashes, want %v", got, tt.fatal)
+			}
+			if got := testutil.ToFloat64(crashes.WithLabelValues(CrashPanic)) - panicked; got != tt.panic {
+				t.Errorf("counted %v panics, want %v", got, tt.panic)
+			}
+			reports, err := r.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reports) != tt.wantReports {
+				t.Fatalf("%d reports, want %d", len(reports), tt.wantReports)
+			}
+			for _, report := range reports {
+				if report.Kind != CrashFatal {
+					continue
+				}
+				data, err := os.ReadFile(filepath.Join(r.dir, report.Name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, want := range []string{"pid: 42\n", "config_hash: old\n", "vcs.revision: abc\n", tt.stderr} {
+					if !strings.Contains(string(data), want) {
+						t.Errorf("fatal report lacks %q:\n%s", want, data)
+					}
+				}
+			}
+		})
+	}
+}
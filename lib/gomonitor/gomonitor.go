@@ -1,41 +1,219 @@
 package gomonitor
 
-import "runtime"
+import (
+	"math"
+	"runtime/metrics"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// GoStats is a snapshot of the runtime. Counters are totals since process
+// start, the rest are current values in bytes or counts.
 type GoStats struct {
-	GCNum        uint32
-	GCPause      uint64
-	MemAllocated uint64
-	MemObjects   uint64
-	MemHeap      uint64
-	MemStack     uint64
-	MemMallocs   uint64
-	GoroutineNum uint32
+	GCNum        uint64
+	HeapGoal     uint64
+	HeapLive     uint64
+	HeapObjects  uint64
+	MemTotal     uint64
+	GoroutineNum uint64
+}
+
+// runtimeMetric maps a runtime/metrics key to a Prometheus series. keys
+// lists the names used by different Go versions, the first supported wins.
+type runtimeMetric struct {
+	keys      []string
+	name      string
+	help      string
+	valueType prometheus.ValueType
+	// buckets is set for distributions, which are exported as histograms.
+	buckets []float64
+}
+
+var latencyBuckets = []float64{1e-6, 5e-6, 1e-5, 5e-5, 1e-4, 5e-4, 1e-3, 5e-3, .01, .05, .1, .5, 1, 5, 10}
+
+var runtimeMetrics = []runtimeMetric{
+	{keys: []string{"/sched/goroutines:goroutines"}, name: "go_goroutines",
+		help: "Number of goroutines that currently exist.", valueType: prometheus.GaugeValue},
+	{keys: []string{"/gc/cycles/total:gc-cycles"}, name: "go_gc_cycles_total",
+		help: "Number of completed GC cycles.", valueType: prometheus.CounterValue},
+	{keys: []string{"/sched/pauses/total/gc:seconds", "/gc/pauses:seconds"}, name: "go_gc_pause_seconds",
+		help: "Distribution of stop-the-world pause latencies caused by the GC.", buckets: latencyBuckets},
+	{keys: []string{"/sched/latencies:seconds"}, name: "go_sched_latencies_seconds",
+		help: "Distribution of the time goroutines spent runnable before running.", buckets: latencyBuckets},
+	{keys: []string{"/gc/heap/goal:bytes"}, name: "go_gc_heap_goal_bytes",
+		help: "Heap size target for the end of the GC cycle.", valueType: prometheus.GaugeValue},
+	{keys: []string{"/gc/heap/live:bytes", "/memory/classes/heap/objects:bytes"}, name: "go_gc_heap_live_bytes",
+		help: "Heap memory occupied by live objects as of the last GC.", valueType: prometheus.GaugeValue},
+	{keys: []string{"/gc/heap/objects:objects"}, name: "go_gc_heap_objects",
+		help: "Number of objects, live or unswept, occupying heap memory.", valueType: prometheus.GaugeValue},
+	{keys: []string{"/gc/heap/allocs:bytes"}, name: "go_gc_heap_allocs_bytes_total",
+		help: "Cumulative bytes allocated on the heap.", valueType: prometheus.CounterValue},
+	{keys: []string{"/memory/classes/total:bytes"}, name: "go_memory_total_bytes",
+		help: "All memory mapped by the Go runtime.", valueType: prometheus.GaugeValue},
+	{keys: []string{"/sched/gomaxprocs:threads"}, name: "go_sched_gomaxprocs_threads",
+		help: "The current runtime.GOMAXPROCS setting.", valueType: prometheus.GaugeValue},
+	{keys: []string{"/gc/gomemlimit:bytes"}, name: "go_gc_gomemlimit_bytes",
+		help: "Go runtime memory limit configured by the user, otherwise math.MaxInt64.", valueType: prometheus.GaugeValue},
+	{keys: []string{"/sync/mutex/wait/total:seconds"}, name: "go_sync_mutex_wait_seconds_total",
+		help: "Cumulative time goroutines spent blocked on a sync.Mutex or sync.RWMutex.", valueType: prometheus.CounterValue},
+}
+
+// supportedKey returns the runtime/metrics key this Go version offers for m.
+func supportedKey(m runtimeMetric, supported map[string]bool) (string, bool) {
+	for _, k := range m.keys {
+		if supported[k] {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// Collector exports runtime/metrics at scrape time. Unlike
+// runtime.ReadMemStats, reading runtime/metrics does not stop the world.
+type Collector struct {
+	metrics []runtimeMetric
+	keys    []string
+	descs   []*prometheus.Desc
 }
 
-var goMoPtr goMonitor
+func NewCollector() *Collector {
+	supported := make(map[string]bool)
+	for _, d := range metrics.All() {
+		supported[d.Name] = true
+	}
+	c := &Collector{}
+	for _, m := range runtimeMetrics {
+		key, ok := supportedKey(m, supported)
+		if !ok {
+			continue
+		}
+		c.metrics = append(c.metrics, m)
+		c.keys = append(c.keys, key)
+		c.descs = append(c.descs, prometheus.NewDesc(m.name, m.help, nil, nil))
+	}
+	return c
+}
 
-type goMonitor struct {
-	lastMem runtime.MemStats
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range c.descs {
+		ch <- d
+	}
 }
 
-func (gm *goMonitor) getState() GoStats {
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	samples := read(c.keys)
+	for i, m := range c.metrics {
+		v := samples[i].Value
+		if m.buckets != nil {
+			if v.Kind() != metrics.KindFloat64Histogram {
+				continue
+			}
+			count, sum, buckets := rebucket(v.Float64Histogram(), m.buckets)
+			ch <- prometheus.MustNewConstHistogram(c.descs[i], count, sum, buckets)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.descs[i], m.valueType, float64Value(v))
+	}
+}
 
-	mem := runtime.MemStats{}
-	runtime.ReadMemStats(&mem)
-	gs := GoStats{}
-	gs.GCNum = mem.NumGC - gm.lastMem.NumGC
-	gs.GCPause = (mem.PauseTotalNs - gm.lastMem.PauseTotalNs) / 1000
-	gs.MemAllocated = mem.Alloc
-	gs.MemObjects = mem.HeapObjects
-	gs.MemHeap = mem.HeapAlloc
-	gs.MemStack = mem.StackInuse
-	gs.MemMallocs = mem.Mallocs - gm.lastMem.Mallocs
-	gs.GoroutineNum = uint32(runtime.NumGoroutine())
-	gm.lastMem = mem
-	return gs
+// Register adds the runtime collector and the standard process collector,
+// which reports FDs, RSS and CPU time, to reg, the cgroup limits if Tune
+// ran, and the crash counter.
+func Register(reg prometheus.Registerer) error {
+	if err := reg.Register(NewCollector()); err != nil {
+		return err
+	}
+	if err := reg.Register(crashes); err != nil {
+		return err
+	}
+	for _, kind := range []string{CrashFatal, CrashPanic, CrashRecovered} {
+		crashes.WithLabelValues(kind)
+	}
+	if err := registerTuning(reg); err != nil {
+		return err
+	}
+	return reg.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
 }
 
+// GetState takes a GoStats snapshot. It is safe for concurrent use.
 func GetState() GoStats {
-	return goMoPtr.getState()
+	keys := []string{
+		"/gc/cycles/total:gc-cycles",
+		"/gc/heap/goal:bytes",
+		"/gc/heap/live:bytes",
+		"/gc/heap/objects:objects",
+		"/memory/classes/total:bytes",
+		"/sched/goroutines:goroutines",
+	}
+	s := read(keys)
+	return GoStats{
+		GCNum:        uint64Value(s[0].Value),
+		HeapGoal:     uint64Value(s[1].Value),
+		HeapLive:     uint64Value(s[2].Value),
+		HeapObjects:  uint64Value(s[3].Value),
+		MemTotal:     uint64Value(s[4].Value),
+		GoroutineNum: uint64Value(s[5].Value),
+	}
+}
+
+func read(keys []string) []metrics.Sample {
+	samples := make([]metrics.Sample, len(keys))
+	for i, k := range keys {
+		samples[i].Name = k
+	}
+	metrics.Read(samples)
+	return samples
+}
+
+func float64Value(v metrics.Value) float64 {
+	switch v.Kind() {
+	case metrics.KindUint64:
+		return float64(v.Uint64())
+	case metrics.KindFloat64:
+		return v.Float64()
+	}
+	return 0
+}
+
+func uint64Value(v metrics.Value) uint64 {
+	switch v.Kind() {
+	case metrics.KindUint64:
+		return v.Uint64()
+	case metrics.KindFloat64:
+		return uint64(v.Float64())
+	}
+	return 0
+}
+
+// rebucket folds a runtime histogram into cumulative counts at bounds. The
+// runtime does not track the sum, so it is estimated from bucket midpoints.
+func rebucket(h *metrics.Float64Histogram, bounds []float64) (uint64, float64, map[float64]uint64) {
+	buckets := make(map[float64]uint64, len(bounds))
+	var count uint64
+	var sum float64
+	b := 0
+	for i, n := range h.Counts {
+		lo, hi := h.Buckets[i], h.Buckets[i+1]
+		for b < len(bounds) && bounds[b] < hi {
+			buckets[bounds[b]] = count
+			b++
+		}
+		count += n
+		if n == 0 {
+			continue
+		}
+		switch {
+		case math.IsInf(lo, -1):
+			sum += hi * float64(n)
+		case math.IsInf(hi, 1):
+			sum += lo * float64(n)
+		default:
+			sum += (lo + hi) / 2 * float64(n)
+		}
+	}
+	for ; b < len(bounds); b++ {
+		buckets[bounds[b]] = count
+	}
+	return count, sum, buckets
 }
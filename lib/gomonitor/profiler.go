@@ -0,0 +1,381 @@
+package gomonitor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"prometheus-test/lib/logger"
+)
+
+const (
+	ProfileHeap      = "heap"
+	ProfileGoroutine = "goroutine"
+	ProfileCPU       = "cpu"
+	ProfileMutex     = "mutex"
+)
+
+// ProfileConf is the [profile] section. Profiles are captured into Dir
+// when a threshold is crossed, at most once per MinInterval, and every
+// Schedule if set.
+type ProfileConf struct {
+	Enable bool   `toml:"enable"`
+	Dir    string `toml:"dir"`
+	// Profiles defaults to heap, goroutine, cpu and mutex.
+	Profiles []string `toml:"profiles"`
+	// HeapBytes triggers when the live heap is above it.
+	HeapBytes uint64 `toml:"heap_bytes"`
+	// Goroutines triggers when more goroutines exist.
+	Goroutines uint64 `toml:"goroutines"`
+	// GoroutineGrowth triggers when goroutines grew by that ratio, e.g.
+	// 0.5 for 50%, within GrowthWindow.
+	GoroutineGrowth float64 `toml:"goroutine_growth"`
+	GrowthWindow    string  `toml:"growth_window"`
+	CheckInterval   string  `toml:"check_interval"`
+	MinInterval     string  `toml:"min_interval"`
+	Schedule        string  `toml:"schedule"`
+	CPUDuration     string  `toml:"cpu_duration"`
+	// MaxCaptures and MaxAge bound the captures kept in Dir.
+	MaxCaptures int    `toml:"max_captures"`
+	MaxAge      string `toml:"max_age"`
+}
+
+// Capture is a directory of profiles taken at one time for one reason.
+type Capture struct {
+	Name   string    `json:"name"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+	Files  []string  `json:"files"`
+	Bytes  int64     `json:"bytes"`
+}
+
+// Profiler captures profiles on thresholds and on a schedule.
+type Profiler struct {
+	dir             string
+	profiles        []string
+	heapBytes       uint64
+	goroutines      uint64
+	goroutineGrowth float64
+	growthWindow    time.Duration
+	checkInterval   time.Duration
+	minInterval     time.Duration
+	schedule        time.Duration
+	cpuDuration     time.Duration
+	maxCaptures     int
+	maxAge          time.Duration
+
+	// mu serializes captures, a CPU profile can only run once.
+	mu          sync.Mutex
+	lastTrigger time.Time
+	history     []goroutineSample
+	stop        chan struct{}
+	done        chan struct{}
+	started     bool
+	stopOnce    sync.Once
+}
+
+type goroutineSample struct {
+	at time.Time
+	n  uint64
+}
+
+var defaultProfiler *Profiler
+
+// captureTimeLayout names capture directories so they sort by time.
+const captureTimeLayout = "20060102-150405"
+
+// NewProfiler checks conf and creates its Dir. Call Start to watch the
+// thresholds and Stop on shutdown.
+func NewProfiler(conf ProfileConf) (*Profiler, error) {
+	p := &Profiler{
+		dir:             conf.Dir,
+		profiles:        conf.Profiles,
+		heapBytes:       conf.HeapBytes,
+		goroutines:      conf.Goroutines,
+		goroutineGrowth: conf.GoroutineGrowth,
+		maxCaptures:     conf.MaxCaptures,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	if p.dir == "" {
+		p.dir = "./logs/profiles"
+	}
+	if len(p.profiles) == 0 {
+		p.profiles = []string{ProfileHeap, ProfileGoroutine, ProfileCPU, ProfileMutex}
+	}
+	for _, name := range p.profiles {
+		switch name {
+		case ProfileHeap, ProfileGoroutine, ProfileCPU, ProfileMutex:
+		default:
+			return nil, fmt.Errorf("unknown profile %q", name)
+		}
+	}
+	if p.maxCaptures == 0 {
+		p.maxCaptures = 20
+	}
+	durations := []struct {
+		s   string
+		def time.Duration
+		d   *time.Duration
+	}{
+		{conf.GrowthWindow, 5 * time.Minute, &p.growthWindow},
+		{conf.CheckInterval, 10 * time.Second, &p.checkInterval},
+		{conf.MinInterval, 10 * time.Minute, &p.minInterval},
+		{conf.Schedule, 0, &p.schedule},
+		{conf.CPUDuration, 10 * time.Second, &p.cpuDuration},
+		{conf.MaxAge, 72 * time.Hour, &p.maxAge},
+	}
+	for _, d := range durations {
+		if d.s == "" {
+			*d.d = d.def
+			continue
+		}
+		v, err := time.ParseDuration(d.s)
+		if err != nil {
+			return nil, err
+		}
+		*d.d = v
+	}
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// InitProfiler creates the Profiler returned by DefaultProfiler.
+func InitProfiler(conf ProfileConf) (*Profiler, error) {
+	p, err := NewProfiler(conf)
+	if err != nil {
+		return nil, err
+	}
+	defaultProfiler = p
+	return p, nil
+}
+
+// DefaultProfiler returns the Profiler created by InitProfiler, or nil.
+func DefaultProfiler() *Profiler {
+	return defaultProfiler
+}
+
+func (p *Profiler) Start() {
+	p.started = true
+	go func() {
+		defer close(p.done)
+		check := time.NewTicker(p.checkInterval)
+		defer check.Stop()
+		var scheduled <-chan time.Time
+		if p.schedule > 0 {
+			ticker := time.NewTicker(p.schedule)
+			defer ticker.Stop()
+			scheduled = ticker.C
+		}
+		for {
+			select {
+			case <-check.C:
+				if reason := p.trigger(GetState(), time.Now()); reason != "" {
+					p.capture(reason)
+				}
+			case <-scheduled:
+				p.capture("schedule")
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the watching, waiting for a capture in progress. It matches
+// recycle.ResourceRecyclable.
+func (p *Profiler) Stop() bool {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		if p.started {
+			<-p.done
+		}
+	})
+	return true
+}
+
+// trigger returns why stats call for a capture, or "" if they don't or
+// the last triggered capture is younger than the min interval.
+func (p *Profiler) trigger(stats GoStats, now time.Time) string {
+	p.history = append(p.history, goroutineSample{at: now, n: stats.GoroutineNum})
+	for len(p.history) > 1 && now.Sub(p.history[0].at) > p.growthWindow {
+		p.history = p.history[1:]
+	}
+	reason := ""
+	switch {
+	case p.heapBytes > 0 && stats.HeapLive > p.heapBytes:
+		reason = "heap"
+	case p.goroutines > 0 && stats.GoroutineNum > p.goroutines:
+		reason = "goroutines"
+	case p.goroutineGrowth > 0 && p.grown(stats.GoroutineNum):
+		reason = "goroutine-growth"
+	}
+	if reason == "" || now.Sub(p.lastTrigger) < p.minInterval {
+		return ""
+	}
+	p.lastTrigger = now
+	return reason
+}
+
+// grown compares n with the fewest goroutines seen within the window.
+func (p *Profiler) grown(n uint64) bool {
+	least := n
+	for _, s := range p.history {
+		if s.n < least {
+			least = s.n
+		}
+	}
+	return least > 0 && float64(n-least)/float64(least) >= p.goroutineGrowth
+}
+
+func (p *Profiler) capture(reason string) {
+	name, err := p.Capture(reason)
+	if err != nil {
+		logger.NotCtxErrorf("capture profiles failed,reason=%s,err=%v", reason, err)
+		return
+	}
+	logger.NotCtxInfof("captured profiles,reason=%s,capture=%s", reason, name)
+}
+
+// Capture writes the configured profiles into a new capture directory and
+// returns its name. Heap and goroutines are taken first, then CPU and
+// mutex contention are recorded for the CPU duration.
+func (p *Profiler) Capture(reason string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	name := now.Format(captureTimeLayout) + "-" + reason
+	dir := filepath.Join(p.dir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	var errs []error
+	for _, profile := range []string{ProfileHeap, ProfileGoroutine} {
+		if p.wants(profile) {
+			errs = append(errs, writeProfile(dir, profile))
+		}
+	}
+	if p.wants(ProfileCPU) || p.wants(ProfileMutex) {
+		errs = append(errs, p.captureWindow(dir))
+	}
+	p.prune(now)
+	return name, errors.Join(errs...)
+}
+
+func (p *Profiler) wants(profile string) bool {
+	for _, name := range p.profiles {
+		if name == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// captureWindow profiles the CPU and, with sampling raised for the
+// window, mutex contention.
+func (p *Profiler) captureWindow(dir string) error {
+	var cpu *os.File
+	if p.wants(ProfileCPU) {
+		f, err := os.Create(filepath.Join(dir, ProfileCPU+".pprof"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// Fails while someone else runs a CPU profile, e.g. through the
+		// pprof endpoint.
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		cpu = f
+	}
+	if p.wants(ProfileMutex) {
+		prev := runtime.SetMutexProfileFraction(5)
+		defer runtime.SetMutexProfileFraction(prev)
+	}
+	select {
+	case <-time.After(p.cpuDuration):
+	case <-p.stop:
+	}
+	if cpu != nil {
+		pprof.StopCPUProfile()
+	}
+	if p.wants(ProfileMutex) {
+		return writeProfile(dir, ProfileMutex)
+	}
+	return nil
+}
+
+func writeProfile(dir string, profile string) error {
+	f, err := os.Create(filepath.Join(dir, profile+".pprof"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return pprof.Lookup(profile).WriteTo(f, 0)
+}
+
+// prune removes captures beyond the max count or older than the max age.
+func (p *Profiler) prune(now time.Time) {
+	captures, err := p.List()
+	if err != nil {
+		return
+	}
+	for i, c := range captures {
+		if i >= p.maxCaptures || now.Sub(c.Time) > p.maxAge {
+			_ = os.RemoveAll(filepath.Join(p.dir, c.Name))
+		}
+	}
+}
+
+// List returns the captures in Dir, newest first.
+func (p *Profiler) List() ([]Capture, error) {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, err
+	}
+	var captures []Capture
+	for _, e := range entries {
+		if !e.IsDir() || len(e.Name()) <= len(captureTimeLayout) {
+			continue
+		}
+		at, err := time.ParseInLocation(captureTimeLayout, e.Name()[:len(captureTimeLayout)], time.Local)
+		if err != nil {
+			continue
+		}
+		c := Capture{Name: e.Name(), Reason: e.Name()[len(captureTimeLayout)+1:], Time: at}
+		files, _ := os.ReadDir(filepath.Join(p.dir, e.Name()))
+		for _, f := range files {
+			if info, err := f.Info(); err == nil && !f.IsDir() {
+				c.Files = append(c.Files, f.Name())
+				c.Bytes += info.Size()
+			}
+		}
+		captures = append(captures, c)
+	}
+	sort.Slice(captures, func(i, j int) bool { return captures[i].Name > captures[j].Name })
+	return captures, nil
+}
+
+// Path returns the path of a file of a capture, refusing names that
+// would leave Dir.
+func (p *Profiler) Path(capture string, file string) (string, error) {
+	for _, name := range []string{capture, file} {
+		if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return "", fmt.Errorf("bad name %q", name)
+		}
+	}
+	path := filepath.Join(p.dir, capture, file)
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
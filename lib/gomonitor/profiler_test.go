@@ -0,0 +1,136 @@
+package gomonitor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestProfiler(t *testing.T, conf ProfileConf) *Profiler {
+	t.Helper()
+	conf.Dir = t.TempDir()
+	p, err := NewProfiler(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestTrigger(t *testing.T) {
+	p := newTestProfiler(t, ProfileConf{
+		HeapBytes:       100,
+		Goroutines:      1000,
+		GoroutineGrowth: 0.5,
+		GrowthWindow:    "1m",
+		MinInterval:     "10m",
+	})
+	start := time.Now()
+	steps := []struct {
+		after time.Duration
+		stats GoStats
+		want  string
+	}{
+		{0, GoStats{HeapLive: 50, GoroutineNum: 100}, ""},
+		{10 * time.Second, GoStats{HeapLive: 50, GoroutineNum: 149}, ""},
+		{20 * time.Second, GoStats{HeapLive: 50, GoroutineNum: 150}, "goroutine-growth"},
+		// Within the min interval of the last trigger.
+		{30 * time.Second, GoStats{HeapLive: 200, GoroutineNum: 150}, ""},
+		{11 * time.Minute, GoStats{HeapLive: 200, GoroutineNum: 150}, "heap"},
+		{22 * time.Minute, GoStats{HeapLive: 50, GoroutineNum: 2000}, "goroutines"},
+		// Earlier samples left the window, so 900 is no growth.
+		{33 * time.Minute, GoStats{HeapLive: 50, GoroutineNum: 900}, ""},
+	}
+	for i, step := range steps {
+		if got := p.trigger(step.stats, start.Add(step.after)); got != step.want {
+			t.Errorf("step %d: trigger = %q, want %q", i, got, step.want)
+		}
+	}
+}
+
+func TestGrown(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		history []uint64
+		n       uint64
+		want    bool
+	}{
+		{"no history", nil, 100, false},
+		{"below ratio", []uint64{100, 120}, 149, false},
+		{"at ratio", []uint64{100, 120}, 150, true},
+		{"from the least", []uint64{300, 100, 200}, 150, true},
+		{"shrunk", []uint64{300}, 100, false},
+		{"from zero", []uint64{0}, 100, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Profiler{goroutineGrowth: 0.5}
+			for _, n := range tt.history {
+				p.history = append(p.history, goroutineSample{n: n})
+			}
+			if got := p.grown(tt.n); got != tt.want {
+				t.Errorf("grown(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	p := newTestProfiler(t, ProfileConf{MaxCaptures: 2, MaxAge: "1h"})
+	now := time.Now()
+	names := map[string]time.Time{}
+	for _, age := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 2 * time.Hour} {
+		at := now.Add(-age)
+		name := at.Format(captureTimeLayout) + "-test"
+		names[name] = at
+		writeTree(t, p.dir, map[string]string{filepath.Join(name, "heap.pprof"): "x"})
+	}
+	// Not a capture, left alone.
+	writeTree(t, p.dir, map[string]string{"notes/readme": "x"})
+
+	p.prune(now)
+	captures, err := p.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range captures {
+		got = append(got, c.Name)
+	}
+	want := []string{
+		now.Add(-time.Minute).Format(captureTimeLayout) + "-test",
+		now.Add(-2*time.Minute).Format(captureTimeLayout) + "-test",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(p.dir, "notes")); err != nil {
+		t.Errorf("prune removed a directory that is no capture: %v", err)
+	}
+}
+
+func TestPath(t *testing.T) {
+	p := newTestProfiler(t, ProfileConf{})
+	capture := time.Now().Format(captureTimeLayout) + "-test"
+	writeTree(t, p.dir, map[string]string{
+		filepath.Join(capture, "heap.pprof"): "x",
+		".hidden/heap.pprof":                 "x",
+	})
+	if path, err := p.Path(capture, "heap.pprof"); err != nil || path != filepath.Join(p.dir, capture, "heap.pprof") {
+		t.Errorf("Path of a capture file = %q, %v", path, err)
+	}
+	for _, tt := range []struct{ capture, file string }{
+		{capture, "cpu.pprof"},
+		{"", "heap.pprof"},
+		{capture, ""},
+		{"..", "heap.pprof"},
+		{capture + "/..", "heap.pprof"},
+		{capture, "../" + capture},
+		{".hidden", "heap.pprof"},
+		{"/etc", "passwd"},
+	} {
+		if path, err := p.Path(tt.capture, tt.file); err == nil {
+			t.Errorf("Path(%q, %q) = %q, want an error", tt.capture, tt.file, path)
+		}
+	}
+}
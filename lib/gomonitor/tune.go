@@ -0,0 +1,221 @@
+package gomonitor
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TuneConf is the [runtime] section. Without overrides GOMAXPROCS and
+// GOMEMLIMIT follow the cgroup limits of the container, unless set in the
+// environment.
+type TuneConf struct {
+	Disable bool `toml:"disable"`
+	// GoMaxProcs overrides the value derived from the CPU quota.
+	GoMaxProcs int `toml:"gomaxprocs"`
+	// CPURatio scales the CPU quota, 1 by default.
+	CPURatio float64 `toml:"cpu_ratio"`
+	// MemLimit overrides the value derived from the memory limit, in bytes.
+	MemLimit int64 `toml:"mem_limit"`
+	// MemRatio is the share of the memory limit given to GOMEMLIMIT, 0.9
+	// by default, leaving headroom for memory the Go runtime doesn't
+	// manage.
+	MemRatio float64 `toml:"mem_ratio"`
+}
+
+// Tuning is what Tune found and chose. Zero limits mean none was found.
+type Tuning struct {
+	CgroupVersion int
+	CPUQuota      float64
+	MemoryLimit   int64
+	GoMaxProcs    int
+	GoMemLimit    int64
+}
+
+var tuning *Tuning
+
+// Tune sets GOMAXPROCS and GOMEMLIMIT from conf and the cgroup limits of
+// the process. Register exports the result.
+func Tune(conf TuneConf) Tuning {
+	t := tune(conf, "/", runtime.NumCPU())
+	tuning = &t
+	return t
+}
+
+func tune(conf TuneConf, root string, numCPU int) Tuning {
+	limits := readCgroup(root)
+	t := Tuning{
+		CgroupVersion: limits.version,
+		CPUQuota:      limits.cpu,
+		MemoryLimit:   limits.memory,
+	}
+	if !conf.Disable {
+		if procs := maxProcs(conf, limits.cpu, numCPU); procs > 0 {
+			runtime.GOMAXPROCS(procs)
+		}
+		if limit := memLimit(conf, limits.memory); limit > 0 {
+			debug.SetMemoryLimit(limit)
+		}
+	}
+	t.GoMaxProcs = runtime.GOMAXPROCS(0)
+	t.GoMemLimit = debug.SetMemoryLimit(-1)
+	return t
+}
+
+// maxProcs returns the GOMAXPROCS to set, or 0 to keep the current one.
+func maxProcs(conf TuneConf, quota float64, numCPU int) int {
+	if conf.GoMaxProcs > 0 {
+		return conf.GoMaxProcs
+	}
+	if quota <= 0 || os.Getenv("GOMAXPROCS") != "" {
+		return 0
+	}
+	ratio := conf.CPURatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	procs := int(math.Floor(quota * ratio))
+	if procs < 1 {
+		procs = 1
+	}
+	if procs > numCPU {
+		procs = numCPU
+	}
+	return procs
+}
+
+// memLimit returns the GOMEMLIMIT to set, or 0 to keep the current one.
+func memLimit(conf TuneConf, limit int64) int64 {
+	if conf.MemLimit > 0 {
+		return conf.MemLimit
+	}
+	if limit <= 0 || os.Getenv("GOMEMLIMIT") != "" {
+		return 0
+	}
+	ratio := conf.MemRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = 0.9
+	}
+	return int64(float64(limit) * ratio)
+}
+
+type cgroupLimits struct {
+	version int
+	// cpu is the quota in cores.
+	cpu    float64
+	memory int64
+}
+
+// readCgroup reads the limits of the process's cgroup, with all paths
+// below root so tests can use a fake tree.
+func readCgroup(root string) cgroupLimits {
+	paths := procCgroups(filepath.Join(root, "proc/self/cgroup"))
+	mount := filepath.Join(root, "sys/fs/cgroup")
+	if _, err := os.Stat(filepath.Join(mount, "cgroup.controllers")); err == nil {
+		l := cgroupLimits{version: 2}
+		if fields := strings.Fields(readCgroupFile(mount, paths[""], "cpu.max")); len(fields) == 2 {
+			l.cpu = quota(fields[0], fields[1])
+		}
+		l.memory = parseLimit(readCgroupFile(mount, paths[""], "memory.max"))
+		return l
+	}
+	cpuDir := filepath.Join(mount, "cpu,cpuacct")
+	if _, err := os.Stat(cpuDir); err != nil {
+		cpuDir = filepath.Join(mount, "cpu")
+	}
+	memDir := filepath.Join(mount, "memory")
+	if _, err := os.Stat(memDir); err != nil {
+		return cgroupLimits{}
+	}
+	l := cgroupLimits{version: 1}
+	l.cpu = quota(readCgroupFile(cpuDir, paths["cpu"], "cpu.cfs_quota_us"),
+		readCgroupFile(cpuDir, paths["cpu"], "cpu.cfs_period_us"))
+	l.memory = parseLimit(readCgroupFile(memDir, paths["memory"], "memory.limit_in_bytes"))
+	return l
+}
+
+// procCgroups maps controllers to cgroup paths from /proc/self/cgroup.
+// The v2 unified hierarchy is under "".
+func procCgroups(file string) map[string]string {
+	paths := make(map[string]string)
+	f, err := os.Open(file)
+	if err != nil {
+		return paths
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	return paths
+}
+
+// readCgroupFile reads name in the process's own cgroup, falling back to
+// the mount point, which is the own cgroup inside a cgroup namespace.
+func readCgroupFile(mount string, path string, name string) string {
+	for _, dir := range []string{filepath.Join(mount, path), mount} {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+			return strings.TrimSpace(string(b))
+		}
+	}
+	return ""
+}
+
+// quota turns a quota and period in microseconds into cores. "max" and
+// "-1" mean no quota.
+func quota(q string, period string) float64 {
+	qv, err := strconv.ParseFloat(q, 64)
+	if err != nil || qv <= 0 {
+		return 0
+	}
+	pv, err := strconv.ParseFloat(period, 64)
+	if err != nil || pv <= 0 {
+		return 0
+	}
+	return qv / pv
+}
+
+// parseLimit parses a memory limit. "max", and the huge value cgroup v1
+// reports without a limit, mean none.
+func parseLimit(s string) int64 {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v <= 0 || v >= math.MaxInt64/2 {
+		return 0
+	}
+	return v
+}
+
+// registerTuning exports the cgroup limits Tune found.
+func registerTuning(reg prometheus.Registerer) error {
+	if tuning == nil {
+		return nil
+	}
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"process_cgroup_cpu_quota_cores", "CPU quota of the cgroup in cores, 0 if none.", tuning.CPUQuota},
+		{"process_cgroup_memory_limit_bytes", "Memory limit of the cgroup, 0 if none.", float64(tuning.MemoryLimit)},
+	}
+	for _, g := range gauges {
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: g.name, Help: g.help})
+		gauge.Set(g.value)
+		if err := reg.Register(gauge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package gomonitor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree writes files, keyed by their path below root.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadCgroup(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  cgroupLimits
+	}{
+		{
+			name: "v2 limits",
+			files: map[string]string{
+				"proc/self/cgroup":                 "0::/app\n",
+				"sys/fs/cgroup/cgroup.controllers": "cpu memory\n",
+				"sys/fs/cgroup/app/cpu.max":        "150000 100000\n",
+				"sys/fs/cgroup/app/memory.max":     "536870912\n",
+				"sys/fs/cgroup/cpu.max":            "max 100000\n",
+				"sys/fs/cgroup/memory.max":         "max\n",
+			},
+			want: cgroupLimits{version: 2, cpu: 1.5, memory: 512 << 20},
+		},
+		{
+			name: "v2 max",
+			files: map[string]string{
+				"proc/self/cgroup":                 "0::/app\n",
+				"sys/fs/cgroup/cgroup.controllers": "cpu memory\n",
+				"sys/fs/cgroup/app/cpu.max":        "max 100000\n",
+				"sys/fs/cgroup/app/memory.max":     "max\n",
+			},
+			want: cgroupLimits{version: 2},
+		},
+		{
+			name: "v2 namespace falls back to the mount",
+			files: map[string]string{
+				"proc/self/cgroup":                 "0::/\n",
+				"sys/fs/cgroup/cgroup.controllers": "cpu memory\n",
+				"sys/fs/cgroup/cpu.max":            "50000 100000\n",
+				"sys/fs/cgroup/memory.max":         "1073741824\n",
+			},
+			want: cgroupLimits{version: 2, cpu: 0.5, memory: 1 << 30},
+		},
+		{
+			name: "v2 missing files",
+			files: map[string]string{
+				"sys/fs/cgroup/cgroup.controllers": "\n",
+			},
+			want: cgroupLimits{version: 2},
+		},
+		{
+			name: "v1 limits",
+			files: map[string]string{
+				"proc/self/cgroup": "5:memory:/app\n4:cpu,cpuacct:/app\n",
+				"sys/fs/cgroup/cpu,cpuacct/app/cpu.cfs_quota_us":  "250000\n",
+				"sys/fs/cgroup/cpu,cpuacct/app/cpu.cfs_period_us": "100000\n",
+				"sys/fs/cgroup/memory/app/memory.limit_in_bytes":  "268435456\n",
+				"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us":      "-1\n",
+				"sys/fs/cgroup/memory/memory.limit_in_bytes":      "9223372036854771712\n",
+			},
+			want: cgroupLimits{version: 1, cpu: 2.5, memory: 256 << 20},
+		},
+		{
+			name: "v1 unlimited",
+			files: map[string]string{
+				"proc/self/cgroup":                            "5:memory:/\n4:cpu,cpuacct:/\n",
+				"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us":  "-1\n",
+				"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+				"sys/fs/cgroup/memory/memory.limit_in_bytes":  "9223372036854771712\n",
+			},
+			want: cgroupLimits{version: 1},
+		},
+		{
+			name: "v1 separate cpu mount without period",
+			files: map[string]string{
+				"proc/self/cgroup":                               "5:memory:/app\n4:cpu:/app\n",
+				"sys/fs/cgroup/cpu/app/cpu.cfs_quota_us":         "100000\n",
+				"sys/fs/cgroup/memory/app/memory.limit_in_bytes": "0\n",
+			},
+			want: cgroupLimits{version: 1},
+		},
+		{
+			name:  "no cgroup",
+			files: map[string]string{"proc/self/cgroup": ""},
+			want:  cgroupLimits{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, tt.files)
+			if got := readCgroup(root); got != tt.want {
+				t.Errorf("readCgroup() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxProcs(t *testing.T) {
+	t.Setenv("GOMAXPROCS", "")
+	tests := []struct {
+		name   string
+		conf   TuneConf
+		quota  float64
+		numCPU int
+		want   int
+	}{
+		{"no quota", TuneConf{}, 0, 8, 0},
+		{"rounds down", TuneConf{}, 2.5, 8, 2},
+		{"at least one", TuneConf{}, 0.2, 8, 1},
+		{"at most the cpus", TuneConf{}, 16, 8, 8},
+		{"ratio", TuneConf{CPURatio: 2}, 1.5, 8, 3},
+		{"override", TuneConf{GoMaxProcs: 5}, 2, 8, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maxProcs(tt.conf, tt.quota, tt.numCPU); got != tt.want {
+				t.Errorf("maxProcs() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemLimit(t *testing.T) {
+	t.Setenv("GOMEMLIMIT", "")
+	tests := []struct {
+		name  string
+		conf  TuneConf
+		limit int64
+		want  int64
+	}{
+		{"no limit", TuneConf{}, 0, 0},
+		{"default ratio", TuneConf{}, 1000, 900},
+		{"ratio", TuneConf{MemRatio: 0.5}, 1000, 500},
+		{"bad ratio", TuneConf{MemRatio: 2}, 1000, 900},
+		{"override", TuneConf{MemLimit: 42}, 1000, 42},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := memLimit(tt.conf, tt.limit); got != tt.want {
+				t.Errorf("memLimit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
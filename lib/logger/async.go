@@ -0,0 +1,208 @@
+package logger
+
+import (
+	"bufio"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// PolicyBlock waits for room in a full queue.
+	PolicyBlock = "block"
+	// PolicyDropNewest drops the line written to a full queue.
+	PolicyDropNewest = "drop_newest"
+	// PolicyDropOldest drops the oldest queued line to make room.
+	PolicyDropOldest = "drop_oldest"
+)
+
+// AsyncConf is the [log.async] section. Log calls queue their lines and
+// a goroutine writes them, so a slow disk doesn't hold up requests.
+type AsyncConf struct {
+	Enable bool `toml:"enable"`
+	// QueueSize is the queue length in lines, 8192 by default.
+	QueueSize int `toml:"queue_size"`
+	// Policy for a full queue, PolicyBlock by default.
+	Policy string `toml:"policy"`
+	// FlushInterval writes the lines buffered by the goroutine, "1s" by
+	// default.
+	FlushInterval string `toml:"flush_interval"`
+}
+
+// asyncWriters are the writers of the current loggers, for the queue
+// metrics.
+var (
+	asyncMutex   sync.Mutex
+	asyncWriters = make(map[string]*asyncWriter)
+)
+
+type asyncWriter struct {
+	name     string
+	ws       zapcore.WriteSyncer
+	policy   string
+	interval time.Duration
+	queue    chan []byte
+	flushes  chan chan struct{}
+
+	// mutex is held for reading while queueing, Close takes it for
+	// writing so no line is queued after the goroutine drained the queue.
+	mutex  sync.RWMutex
+	closed bool
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+// newAsyncWriter queues the lines written to ws if conf enables it, and
+// returns ws otherwise.
+func newAsyncWriter(name string, ws zapcore.WriteSyncer, conf AsyncConf) (zapcore.WriteSyncer, error) {
+	if !conf.Enable {
+		return ws, nil
+	}
+	w := &asyncWriter{
+		name:     name,
+		ws:       ws,
+		policy:   conf.Policy,
+		interval: time.Second,
+		flushes:  make(chan chan struct{}),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	switch w.policy {
+	case "":
+		w.policy = PolicyBlock
+	case PolicyBlock, PolicyDropNewest, PolicyDropOldest:
+	default:
+		return nil, fmt.Errorf("unknown log async policy %q", conf.Policy)
+	}
+	size := conf.QueueSize
+	if size <= 0 {
+		size = 8192
+	}
+	w.queue = make(chan []byte, size)
+	if conf.FlushInterval != "" {
+		d, err := time.ParseDuration(conf.FlushInterval)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("log async flush_interval=%s must be positive", d)
+		}
+		w.interval = d
+	}
+	go w.run()
+	asyncMutex.Lock()
+	if prev := asyncWriters[name]; prev != nil {
+		prev.Close()
+	}
+	asyncWriters[name] = w
+	asyncMutex.Unlock()
+	return w, nil
+}
+
+// Write queues a copy of p, zap reuses it. Once closed it writes through.
+func (w *asyncWriter) Write(p []byte) (int, error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return w.ws.Write(p)
+	}
+	line := append([]byte(nil), p...)
+	switch w.policy {
+	case PolicyDropNewest:
+		select {
+		case w.queue <- line:
+		default:
+			droppedLines.WithLabelValues(w.name, reasonQueueFull).Inc()
+		}
+	case PolicyDropOldest:
+		for {
+			select {
+			case w.queue <- line:
+				return len(p), nil
+			default:
+			}
+			select {
+			case <-w.queue:
+				droppedLines.WithLabelValues(w.name, reasonQueueFull).Inc()
+			default:
+			}
+		}
+	default:
+		w.queue <- line
+	}
+	return len(p), nil
+}
+
+// Sync writes out the lines queued so far.
+func (w *asyncWriter) Sync() error {
+	w.mutex.RLock()
+	if !w.closed {
+		flushed := make(chan struct{})
+		w.flushes <- flushed
+		<-flushed
+	}
+	w.mutex.RUnlock()
+	return w.ws.Sync()
+}
+
+// Close writes out the queue and stops the goroutine, later lines are
+// written through. It matches recycle.ResourceRecyclable.
+func (w *asyncWriter) Close() bool {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return true
+	}
+	w.closed = true
+	close(w.quit)
+	w.mutex.Unlock()
+	<-w.done
+	return w.ws.Sync() == nil
+}
+
+func (w *asyncWriter) run() {
+	defer close(w.done)
+	buf := bufio.NewWriterSize(w.ws, 256*1024)
+	write := func(line []byte) {
+		// Lines go out whole, so a rotation never splits one.
+		if buf.Buffered() > 0 && buf.Available() < len(line) {
+			_ = buf.Flush()
+		}
+		_, _ = buf.Write(line)
+	}
+	drain := func() {
+		for n := len(w.queue); n > 0; n-- {
+			write(<-w.queue)
+		}
+		_ = buf.Flush()
+	}
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case line := <-w.queue:
+			write(line)
+		case <-ticker.C:
+			_ = buf.Flush()
+		case flushed := <-w.flushes:
+			drain()
+			close(flushed)
+		case <-w.quit:
+			drain()
+			return
+		}
+	}
+}
+
+// closeAsync closes the writer of the logger name, if it is async.
+func closeAsync(name string) bool {
+	asyncMutex.Lock()
+	w := asyncWriters[name]
+	asyncMutex.Unlock()
+	if w == nil {
+		return true
+	}
+	return w.Close()
+}
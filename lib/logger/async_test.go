@@ -0,0 +1,133 @@
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// lineBuffer is a WriteSyncer recording what reached it.
+type lineBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lineBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lineBuffer) Sync() error { return nil }
+
+func (b *lineBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+// stoppedAsyncWriter is an asyncWriter whose goroutine is not started
+// yet, so its queue fills up.
+func stoppedAsyncWriter(name, policy string, size int) (*asyncWriter, *lineBuffer) {
+	ws := &lineBuffer{}
+	return &asyncWriter{
+		name:     name,
+		ws:       ws,
+		policy:   policy,
+		interval: time.Hour,
+		queue:    make(chan []byte, size),
+		flushes:  make(chan chan struct{}),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, ws
+}
+
+func TestAsyncPolicies(t *testing.T) {
+	for _, tt := range []struct {
+		policy  string
+		want    string
+		dropped float64
+	}{
+		{PolicyBlock, "1\n2\n3\n", 0},
+		{PolicyDropNewest, "1\n2\n", 1},
+		{PolicyDropOldest, "2\n3\n", 1},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			name := "test_" + tt.policy
+			w, ws := stoppedAsyncWriter(name, tt.policy, 2)
+			dropped := droppedLines.WithLabelValues(name, reasonQueueFull)
+			before := testutil.ToFloat64(dropped)
+			for _, line := range []string{"1\n", "2\n"} {
+				if _, err := w.Write([]byte(line)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			written := make(chan struct{})
+			go func() {
+				_, _ = w.Write([]byte("3\n"))
+				close(written)
+			}()
+			select {
+			case <-written:
+				if tt.policy == PolicyBlock {
+					t.Fatal("write to a full queue returned")
+				}
+			case <-time.After(50 * time.Millisecond):
+				if tt.policy != PolicyBlock {
+					t.Fatal("write to a full queue blocked")
+				}
+			}
+			go w.run()
+			<-written
+			if !w.Close() {
+				t.Error("Close failed")
+			}
+			if got := ws.String(); got != tt.want {
+				t.Errorf("wrote %q, want %q", got, tt.want)
+			}
+			if got := testutil.ToFloat64(dropped) - before; got != tt.dropped {
+				t.Errorf("dropped %v lines, want %v", got, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestAsyncFlushesOnClose(t *testing.T) {
+	ws := &lineBuffer{}
+	aws, err := newAsyncWriter("test_close", ws, AsyncConf{Enable: true, FlushInterval: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := aws.(*asyncWriter)
+	var want strings.Builder
+	for i := 0; i < 100; i++ {
+		line := strings.Repeat("x", i) + "\n"
+		want.WriteString(line)
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !w.Close() {
+		t.Error("Close failed")
+	}
+	if got := ws.String(); got != want.String() {
+		t.Errorf("wrote %d bytes before Close returned, want %d", len(got), want.Len())
+	}
+	// Lines after Close are written through.
+	if _, err := w.Write([]byte("late\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := ws.String(); !strings.HasSuffix(got, "late\n") {
+		t.Error("line after Close was not written through")
+	}
+}
+
+func TestAsyncRejectsUnknownPolicy(t *testing.T) {
+	if _, err := newAsyncWriter("test_policy", &lineBuffer{}, AsyncConf{Enable: true, Policy: "drop_all"}); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
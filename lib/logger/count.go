@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap/zapcore"
+)
+
+// countEntries is a core hook counting the entries of the logger name by
+// level.
+func countEntries(name string) func(zapcore.Entry) error {
+	counters := make(map[zapcore.Level]prometheus.Counter)
+	for l := zapcore.DebugLevel; l <= zapcore.FatalLevel; l++ {
+		counters[l] = entries.WithLabelValues(name, l.String())
+	}
+	return func(ent zapcore.Entry) error {
+		if c, ok := counters[ent.Level]; ok {
+			c.Inc()
+		}
+		return nil
+	}
+}
+
+// countedWriter counts the errors of the writes and syncs of the logger
+// name.
+type countedWriter struct {
+	zapcore.WriteSyncer
+	writeErrors prometheus.Counter
+	syncErrors  prometheus.Counter
+}
+
+func newCountedWriter(name string, ws zapcore.WriteSyncer) zapcore.WriteSyncer {
+	return countedWriter{
+		WriteSyncer: ws,
+		writeErrors: writeErrors.WithLabelValues(name, "write"),
+		syncErrors:  writeErrors.WithLabelValues(name, "sync"),
+	}
+}
+
+func (w countedWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteSyncer.Write(p)
+	if err != nil {
+		w.writeErrors.Inc()
+	}
+	return n, err
+}
+
+// Sync doesn't count stdout on a terminal or pipe, which can't be synced.
+func (w countedWriter) Sync() error {
+	err := w.WriteSyncer.Sync()
+	if err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTSUP) {
+		w.syncErrors.Inc()
+	}
+	return err
+}
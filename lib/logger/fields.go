@@ -0,0 +1,103 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"prometheus-test/lib/util"
+)
+
+const (
+	// FormatConsole is the "ts|[LEVEL]|request_id|msg" line format.
+	FormatConsole = "console"
+	// FormatJSON writes one JSON object per line, with the request id,
+	// trace id, route and client ip as fields.
+	FormatJSON = "json"
+)
+
+const (
+	scopeRequest    = "request"
+	scopeBackground = "background"
+)
+
+// structured is set by Init for FormatJSON.
+var structured bool
+
+// GetJSONEncoder is the encoder of FormatJSON.
+func GetJSONEncoder() zapcore.Encoder {
+	return zapcore.NewJSONEncoder(
+		zapcore.EncoderConfig{
+			TimeKey:        "ts",
+			LevelKey:       "level",
+			NameKey:        "logger",
+			CallerKey:      "caller",
+			FunctionKey:    zapcore.OmitKey,
+			MessageKey:     "msg",
+			StacktraceKey:  "stacktrace",
+			LineEnding:     zapcore.DefaultLineEnding,
+			EncodeLevel:    zapcore.LowercaseLevelEncoder,
+			EncodeTime:     zapcore.ISO8601TimeEncoder,
+			EncodeDuration: zapcore.SecondsDurationEncoder,
+			EncodeCaller:   zapcore.ShortCallerEncoder,
+		})
+}
+
+// ctxMsg prefixes the request id in the console format, where it is not
+// a field.
+func ctxMsg(c context.Context, msg string) string {
+	if structured {
+		return msg
+	}
+	return util.GetRequestId(c) + "|" + msg
+}
+
+// ctxArgs prepends the fields attached to c by With to args, and the
+// request fields of c before them in the JSON format.
+func ctxArgs(c context.Context, args []interface{}) []interface{} {
+	with := Fields(c)
+	if !structured {
+		if len(with) == 0 {
+			return args
+		}
+		return append(append(make([]interface{}, 0, len(with)+len(args)), with...), args...)
+	}
+	fields := make([]interface{}, 0, len(with)+len(args)+5)
+	fields = append(fields, zap.String("scope", scopeRequest), zap.String("request_id", util.GetRequestId(c)))
+	if traceId := util.GetTraceId(c); traceId != "" {
+		fields = append(fields, zap.String("trace_id", traceId))
+	}
+	if route := util.GetRoute(c); route != "" {
+		fields = append(fields, zap.String("route", route))
+	}
+	if ip := util.GetClientIP(c); ip != "" {
+		fields = append(fields, zap.String("client_ip", ip))
+	}
+	fields = append(fields, with...)
+	return append(fields, args...)
+}
+
+func notCtxMsg(msg string) string {
+	if structured {
+		return msg
+	}
+	return "notCtx|" + msg
+}
+
+func notCtxArgs(args []interface{}) []interface{} {
+	if !structured {
+		return args
+	}
+	return append([]interface{}{zap.String("scope", scopeBackground)}, args...)
+}
+
+// sprintf formats like the sugared *f methods, which leave a template
+// without args alone.
+func sprintf(template string, args []interface{}) string {
+	if len(args) == 0 {
+		return template
+	}
+	return fmt.Sprintf(template, args...)
+}
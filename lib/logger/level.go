@@ -0,0 +1,114 @@
+package logger
+
+import (
+	"context"
+	"crypto/subtle"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DebugContextKey marks a request whose log lines are written at debug
+// level whatever the current level, see TrustedDebug.
+const DebugContextKey = "log_debug"
+
+var (
+	// level is the level of the business log, changeable at runtime.
+	level = zap.NewAtomicLevel()
+	// dlogger writes to the business log at debug level, for requests
+	// marked with DebugContextKey.
+	dlogger *zap.SugaredLogger
+
+	debugHeader string
+	debugToken  string
+
+	revertMutex sync.Mutex
+	baseLevel   zapcore.Level
+	revertTimer *time.Timer
+	revertAt    time.Time
+	// levelChanges counts SetLevel calls, so the timer of a replaced
+	// change that already fired reverts nothing.
+	levelChanges uint64
+)
+
+// LevelState is the current business log level and when it reverts to
+// the configured one, nil if it doesn't.
+type LevelState struct {
+	Level    string     `json:"level"`
+	RevertAt *time.Time `json:"revert_at,omitempty"`
+}
+
+// GetLevel returns the business log level.
+func GetLevel() LevelState {
+	revertMutex.Lock()
+	defer revertMutex.Unlock()
+	state := LevelState{Level: level.Level().String()}
+	if revertTimer != nil {
+		at := revertAt
+		state.RevertAt = &at
+	}
+	return state
+}
+
+// SetLevel changes the business log level. With revertAfter > 0 the
+// configured level comes back after that long.
+func SetLevel(name string, revertAfter time.Duration) error {
+	l, err := zapcore.ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	revertMutex.Lock()
+	defer revertMutex.Unlock()
+	if revertTimer != nil {
+		revertTimer.Stop()
+		revertTimer = nil
+		revertAt = time.Time{}
+	}
+	levelChanges++
+	level.SetLevel(l)
+	if revertAfter > 0 {
+		change := levelChanges
+		revertAt = time.Now().Add(revertAfter)
+		revertTimer = time.AfterFunc(revertAfter, func() { revertLevel(change) })
+	}
+	return nil
+}
+
+// revertLevel undoes the level change numbered change, unless another
+// one replaced it.
+func revertLevel(change uint64) {
+	revertMutex.Lock()
+	defer revertMutex.Unlock()
+	if change != levelChanges {
+		return
+	}
+	level.SetLevel(baseLevel)
+	revertTimer = nil
+	revertAt = time.Time{}
+}
+
+// DebugHeader is the request header that turns on debug lines for one
+// request, "" when no debug_token is configured.
+func DebugHeader() string {
+	if debugToken == "" {
+		return ""
+	}
+	return debugHeader
+}
+
+// TrustedDebug reports whether a DebugHeader value carries the token.
+func TrustedDebug(value string) bool {
+	return debugToken != "" && subtle.ConstantTimeCompare([]byte(value), []byte(debugToken)) == 1
+}
+
+// bizLogger returns the logger for a line of the request c.
+func bizLogger(c context.Context) *zap.SugaredLogger {
+	if c != nil {
+		if debug, _ := c.Value(DebugContextKey).(bool); debug {
+			return dlogger
+		}
+	}
+	return blogger
+}
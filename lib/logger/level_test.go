@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestSetLevel(t *testing.T) {
+	oldLevel, oldBase := level.Level(), baseLevel
+	baseLevel = zapcore.InfoLevel
+	defer func() {
+		_ = SetLevel(oldLevel.String(), 0)
+		baseLevel = oldBase
+	}()
+	waitLevel := func(want zapcore.Level) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if level.Level() == want {
+				return
+			}
+		}
+		t.Fatalf("level %s, want %s", level.Level(), want)
+	}
+
+	if err := SetLevel("verbose", 0); err == nil {
+		t.Error("unknown level accepted")
+	}
+
+	// A change reverts to the configured level.
+	if err := SetLevel("debug", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if state := GetLevel(); state.Level != "debug" || state.RevertAt == nil {
+		t.Errorf("GetLevel() = %+v, want debug with a revert", state)
+	}
+	waitLevel(zapcore.InfoLevel)
+	if state := GetLevel(); state.RevertAt != nil {
+		t.Errorf("reverted level still has revert_at %v", state.RevertAt)
+	}
+
+	// Without a revert the level stays, and replaces a pending revert.
+	if err := SetLevel("debug", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetLevel("warn", 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if state := GetLevel(); state.Level != "warn" || state.RevertAt != nil {
+		t.Errorf("GetLevel() = %+v, want warn without a revert", state)
+	}
+
+	// The timer of a replaced change that fired before it could be
+	// stopped reverts nothing.
+	if err := SetLevel("debug", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	revertMutex.Lock()
+	stale := levelChanges
+	revertMutex.Unlock()
+	if err := SetLevel("error", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	revertLevel(stale)
+	if state := GetLevel(); state.Level != "error" || state.RevertAt == nil {
+		t.Errorf("after a stale revert GetLevel() = %+v, want error with a revert", state)
+	}
+}
@@ -2,34 +2,111 @@ package logger
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"os"
 	"time"
 
-	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-
-	"prometheus-test/lib/util"
 )
 
 var alogger *zap.SugaredLogger
 var blogger *zap.SugaredLogger
 
+// accessBuffer is set when the access log is buffered.
+var accessBuffer *zapcore.BufferedWriteSyncer
+
 type LoggerConf struct {
-	Level         string `toml:"level"`
-	Business      string `toml:"business"`
-	Access        string `toml:"access"`
-	BusinessLink  string `toml:"business_link"`
+	// Level of the business log, changeable at runtime with SetLevel.
+	Level string `toml:"level"`
+	// Business and Access are strftime patterns of the log files, or
+	// "stdout".
+	Business     string `toml:"business"`
+	Access       string `toml:"access"`
+	BusinessLink string `toml:"business_link"`
+	// Size rotates a file when it reaches that many GB.
 	Size          int    `toml:"size"`
 	AccessLink    string `toml:"access_link"`
 	RotationCount uint   `toml:"rotation_count"`
-	Console       bool   `toml:"console"`
+	// MaxAge deletes rotated files older than that, e.g. "168h".
+	MaxAge string `toml:"max_age"`
+	// Compress gzips rotated files.
+	Compress bool `toml:"compress"`
+	// Console also writes file output to stdout.
+	Console bool `toml:"console"`
+	// Format of the business log, FormatConsole (the default) or
+	// FormatJSON.
+	Format string `toml:"format"`
+	// AccessLog tunes the access log apart from the business log.
+	AccessLog AccessLogConf `toml:"access_log"`
+	// A request whose DebugHeader ("X-Debug-Log" by default) carries
+	// DebugToken gets its lines logged at debug level. No token turns it
+	// off.
+	DebugHeader string `toml:"debug_header"`
+	DebugToken  string `toml:"debug_token"`
+	// Sampling drops repeated business lines.
+	Sampling SamplingConf `toml:"sampling"`
+	// Async takes file and stdout writes off the request path, for both
+	// logs.
+	Async AsyncConf `toml:"async"`
+	// Redact masks sensitive values in both logs.
+	Redact RedactConf `toml:"redact"`
+}
+
+// AccessLogConf is the [log.access_log] section. Zero rotation fields
+// follow the business log.
+type AccessLogConf struct {
+	// Level "warn" or above turns access lines off, "info" by default.
+	Level         string `toml:"level"`
+	Size          int    `toml:"size"`
+	RotationCount uint   `toml:"rotation_count"`
+	MaxAge        string `toml:"max_age"`
+	// BufferSize buffers up to that many KB of access lines, written out
+	// when full and every FlushInterval. 0 writes every line through.
+	BufferSize    int    `toml:"buffer_size"`
+	FlushInterval string `toml:"flush_interval"`
 }
 
 func Init(cfg LoggerConf) error {
+	switch cfg.Format {
+	case "", FormatConsole:
+		structured = false
+	case FormatJSON:
+		structured = true
+	default:
+		return fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	var err error
+	if redaction, err = newRedactor(cfg.Redact); err != nil {
+		return err
+	}
+	baseLevel = parseLevel(cfg.Level)
+	level.SetLevel(baseLevel)
+	debugHeader, debugToken = cfg.DebugHeader, cfg.DebugToken
+	if debugHeader == "" {
+		debugHeader = "X-Debug-Log"
+	}
+	b, d, err := initLogger(cfg.Business, cfg.BusinessLink, level, cfg, zap.AddCaller(), zap.AddCallerSkip(1))
+	if err != nil {
+		return err
+	}
+	a, err := initAccessLogger(cfg)
+	if err != nil {
+		return err
+	}
+	blogger = b.Sugar()
+	dlogger = d.Sugar()
+	alogger = a.Sugar()
+	if sampler != nil {
+		sampler.Stop()
+	}
+	sampler, err = newSampler(cfg.Sampling)
+	return err
+}
+
+func parseLevel(s string) zapcore.Level {
 	level := zap.InfoLevel
-	switch cfg.Level {
+	switch s {
 	case "debug":
 		level = zap.DebugLevel
 	case "info":
@@ -42,46 +119,37 @@ func Init(cfg LoggerConf) error {
 		level = zap.FatalLevel
 	default:
 	}
+	return level
+}
 
-	l, err := initLogger("stdout", "stdout", level, cfg.Size, cfg.RotationCount, zap.AddCaller(), zap.AddCallerSkip(2))
+// initLogger returns the business logger at level, and one at debug
+// level writing to the same file for the requests marked with
+// DebugContextKey.
+func initLogger(logFile string, logFileLink string, level zapcore.LevelEnabler, cfg LoggerConf, options ...zap.Option) (*zap.Logger, *zap.Logger, error) {
+	w, err := newWriteSyncer(logFile, logFileLink, cfg)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	blogger = l.Sugar()
-	alogger = l.Sugar()
-	return nil
-}
-
-func initLogger(logFile string, logFileLink string, level zapcore.Level, size int, rotationCount uint, options ...zap.Option) (*zap.Logger, error) {
-	var w zapcore.WriteSyncer
-	w = zapcore.AddSync(os.Stdout)
-	if logFile != "stdout" {
-		isK8s := os.Getenv("K8S_ENV")
-		if isK8s == "" {
-			logFile = "./logs/business-%Y%m%d.log-%H%M"
-			logFileLink = "./logs/business.log"
-		}
-		rotator, err := rotatelogs.New(
-			logFile,
-			rotatelogs.WithLinkName(logFileLink),
-			rotatelogs.WithRotationSize(int64(size)*1024*1024*1024),
-			rotatelogs.WithRotationTime(time.Hour),
-			rotatelogs.WithRotationCount(rotationCount),
-		)
-		if err != nil {
-			return nil, err
-		}
-		w = zapcore.AddSync(rotator)
+	w = newCountedWriter(loggerBusiness, w)
+	if w, err = newAsyncWriter(loggerBusiness, w, cfg.Async); err != nil {
+		return nil, nil, err
 	}
 
 	Encoder := GetEncoder()
+	if structured {
+		Encoder = GetJSONEncoder()
+	}
 
-	core := zapcore.NewCore(
-		Encoder,
-		w,
-		level,
-	)
-	return zap.New(core, options...), nil
+	hook := countEntries(loggerBusiness)
+	newCore := func(level zapcore.LevelEnabler) zapcore.Core {
+		core := zapcore.NewCore(
+			Encoder,
+			w,
+			level,
+		)
+		return zapcore.RegisterHooks(newRedactCore(core, redaction), hook)
+	}
+	return zap.New(newCore(level), options...), zap.New(newCore(zapcore.DebugLevel), options...), nil
 }
 
 func GetEncoder() zapcore.Encoder {
@@ -108,26 +176,41 @@ func cEncodeLevel(level zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
 	enc.AppendString("[" + level.CapitalString() + "]|")
 }
 
-func initAccessLogger(logFile string, logFileLink string, level zapcore.Level, size int, rotationCount uint, options ...zap.Option) (*zap.Logger, error) {
-	var w zapcore.WriteSyncer
-	w = zapcore.AddSync(os.Stdout)
-	if logFile != "stdout" {
-		isK8s := os.Getenv("K8S_ENV")
-		if isK8s == "" {
-			logFile = "./logs/access-%Y%m%d.log-%H%M"
-			logFileLink = "./logs/access.log"
+// initAccessLogger writes access lines through their own rotation and
+// buffer, so a slow or full business log disk doesn't hold them up.
+func initAccessLogger(cfg LoggerConf, options ...zap.Option) (*zap.Logger, error) {
+	ac := cfg.AccessLog
+	rotation := cfg
+	if ac.Size != 0 {
+		rotation.Size = ac.Size
+	}
+	if ac.RotationCount != 0 {
+		rotation.RotationCount = ac.RotationCount
+	}
+	if ac.MaxAge != "" {
+		rotation.MaxAge = ac.MaxAge
+	}
+	w, err := newWriteSyncer(cfg.Access, cfg.AccessLink, rotation)
+	if err != nil {
+		return nil, err
+	}
+	w = newCountedWriter(loggerAccess, w)
+	if ac.BufferSize > 0 {
+		interval := 30 * time.Second
+		if ac.FlushInterval != "" {
+			if interval, err = time.ParseDuration(ac.FlushInterval); err != nil {
+				return nil, err
+			}
 		}
-		rotator, err := rotatelogs.New(
-			logFile,
-			rotatelogs.WithLinkName(logFileLink),
-			rotatelogs.WithRotationSize(int64(size)*1024*1024*1024),
-			rotatelogs.WithRotationTime(time.Hour),
-			rotatelogs.WithRotationCount(rotationCount),
-		)
-		if err != nil {
-			return nil, err
+		accessBuffer = &zapcore.BufferedWriteSyncer{
+			WS:            w,
+			Size:          ac.BufferSize * 1024,
+			FlushInterval: interval,
 		}
-		w = zapcore.AddSync(rotator)
+		w = accessBuffer
+	}
+	if w, err = newAsyncWriter(loggerAccess, w, cfg.Async); err != nil {
+		return nil, err
 	}
 
 	Encoder := GetAccessEncoder()
@@ -135,15 +218,18 @@ func initAccessLogger(logFile string, logFileLink string, level zapcore.Level, s
 	core := zapcore.NewCore(
 		Encoder,
 		w,
-		level,
+		parseLevel(ac.Level),
 	)
-	return zap.New(core, options...), nil
+	return zap.New(zapcore.RegisterHooks(newRedactCore(core, redaction), countEntries(loggerAccess)), options...), nil
 }
 
+// GetAccessEncoder writes the message alone, access lines are formatted
+// by the caller.
 func GetAccessEncoder() zapcore.Encoder {
 	return zapcore.NewConsoleEncoder(
 		zapcore.EncoderConfig{
 			MessageKey: "msg",
+			LineEnding: zapcore.DefaultLineEnding,
 		})
 }
 
@@ -151,66 +237,100 @@ const (
 	logTmFmtWithMS = "2006-01-02 15:04:05"
 )
 
+// AccessInfo writes an access line, followed by the fields attached to c
+// by With.
 func AccessInfo(c context.Context, msg string, args ...interface{}) {
+	if with := Fields(c); len(with) > 0 {
+		args = append(append(make([]interface{}, 0, len(with)+len(args)), with...), args...)
+	}
 	alogger.Infow(msg, args...)
 }
 
 func Debug(c context.Context, msg string, args ...interface{}) {
-	blogger.Debugw(util.GetRequestId(c)+"|"+msg, args...)
+	if sampled(c, zapcore.DebugLevel, msg, args) {
+		bizLogger(c).Debugw(ctxMsg(c, msg), ctxArgs(c, args)...)
+	}
 }
 
 func Debugf(c context.Context, template string, args ...interface{}) {
-	blogger.Debugf(util.GetRequestId(c)+"|"+template, args...)
+	if sampledf(c, zapcore.DebugLevel, template, args) {
+		bizLogger(c).Debugw(ctxMsg(c, sprintf(template, args)), ctxArgs(c, nil)...)
+	}
 }
 
 func Warn(c context.Context, msg string, args ...interface{}) {
-	blogger.Warnw(util.GetRequestId(c)+"|"+msg, args...)
+	if sampled(c, zapcore.WarnLevel, msg, args) {
+		bizLogger(c).Warnw(ctxMsg(c, msg), ctxArgs(c, args)...)
+	}
 }
 
 func Warnf(c context.Context, msg string, args ...interface{}) {
-	blogger.Warnf(util.GetRequestId(c)+"|"+msg, args...)
+	if sampledf(c, zapcore.WarnLevel, msg, args) {
+		bizLogger(c).Warnw(ctxMsg(c, sprintf(msg, args)), ctxArgs(c, nil)...)
+	}
 }
 
 func Info(c context.Context, msg string, args ...interface{}) {
-	blogger.Infow(util.GetRequestId(c)+"|"+msg, args...)
+	if sampled(c, zapcore.InfoLevel, msg, args) {
+		bizLogger(c).Infow(ctxMsg(c, msg), ctxArgs(c, args)...)
+	}
 }
 
 func Infof(c context.Context, msg string, args ...interface{}) {
-	blogger.Infof(util.GetRequestId(c)+"|"+msg, args...)
+	if sampledf(c, zapcore.InfoLevel, msg, args) {
+		bizLogger(c).Infow(ctxMsg(c, sprintf(msg, args)), ctxArgs(c, nil)...)
+	}
 }
 
 func Error(c context.Context, msg string, args ...interface{}) {
-	blogger.Errorw(util.GetRequestId(c)+"|"+msg, args...)
+	if sampled(c, zapcore.ErrorLevel, msg, args) {
+		bizLogger(c).Errorw(ctxMsg(c, msg), ctxArgs(c, args)...)
+	}
 }
 
 func Errorf(c context.Context, template string, args ...interface{}) {
-	blogger.Errorf(util.GetRequestId(c)+"|"+template, args...)
+	if sampledf(c, zapcore.ErrorLevel, template, args) {
+		bizLogger(c).Errorw(ctxMsg(c, sprintf(template, args)), ctxArgs(c, nil)...)
+	}
 }
 
 func Fatal(c context.Context, msg string, args ...interface{}) {
-	blogger.Fatalw(util.GetRequestId(c)+"|"+msg, args...)
+	bizLogger(c).Fatalw(ctxMsg(c, msg), ctxArgs(c, args)...)
 }
 
 func NotCtxInfo(msg string, args ...interface{}) {
-	blogger.Infow("notCtx|"+msg, args...)
+	if sampled(nil, zapcore.InfoLevel, msg, args) {
+		blogger.Infow(notCtxMsg(msg), notCtxArgs(args)...)
+	}
 }
 func NotCtxInfof(msg string, args ...interface{}) {
-	blogger.Infof("notCtx|"+msg, args...)
+	if sampledf(nil, zapcore.InfoLevel, msg, args) {
+		blogger.Infow(notCtxMsg(sprintf(msg, args)), notCtxArgs(nil)...)
+	}
 }
 func NotCtxFatal(msg string, args ...interface{}) {
-	blogger.Fatalw("notCtx|"+msg, args...)
+	blogger.Fatalw(notCtxMsg(msg), notCtxArgs(args)...)
 }
 func NotCtxFatalf(msg string, args ...interface{}) {
-	blogger.Fatalf("notCtx|"+msg, args...)
+	blogger.Fatalw(notCtxMsg(sprintf(msg, args)), notCtxArgs(nil)...)
 }
 func NotCtxError(msg string, args ...interface{}) {
-	blogger.Errorw("notCtx|"+msg, args...)
+	if sampled(nil, zapcore.ErrorLevel, msg, args) {
+		blogger.Errorw(notCtxMsg(msg), notCtxArgs(args)...)
+	}
 }
 func NotCtxErrorf(msg string, args ...interface{}) {
-	blogger.Errorf("notCtx|"+msg, args...)
+	if sampledf(nil, zapcore.ErrorLevel, msg, args) {
+		blogger.Errorw(notCtxMsg(sprintf(msg, args)), notCtxArgs(nil)...)
+	}
 }
 
 func Close() {
+	if sampler != nil {
+		sampler.Stop()
+		sampler = nil
+	}
+	closeAsync(loggerBusiness)
 	err := blogger.Sync()
 	if err != nil {
 		log.Printf("[DS]BizLogger Close Sync failed,err=%v", err)
@@ -221,6 +341,16 @@ func Close() {
 	}
 }
 
+// CloseAccess flushes queued and buffered access lines and stops the
+// periodic flushes. It matches recycle.ResourceRecyclable.
+func CloseAccess() bool {
+	closeAsync(loggerAccess)
+	if accessBuffer != nil {
+		return accessBuffer.Stop() == nil
+	}
+	return alogger.Sync() == nil
+}
+
 func GetBizLogger() *zap.SugaredLogger {
 	return blogger
 }
@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	loggerBusiness = "business"
+	loggerAccess   = "access"
+
+	reasonSampled   = "sampled"
+	reasonDuplicate = "duplicate"
+	reasonQueueFull = "queue_full"
+)
+
+var droppedLines = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "log_dropped_lines_total",
+	Help: "Log lines not written, by logger and reason.",
+}, []string{"logger", "reason"})
+
+var entries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "log_entries_total",
+	Help: "Log entries written, by logger and level.",
+}, []string{"logger", "level"})
+
+var writeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "log_write_errors_total",
+	Help: "Failed log writes and syncs, by logger and op.",
+}, []string{"logger", "op"})
+
+// Register adds the metrics of the loggers to reg.
+func Register(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{droppedLines, entries, writeErrors, queueCollector{}} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var queueDesc = prometheus.NewDesc("log_queue_lines",
+	"Lines queued by the async log writers, by logger.", []string{"logger"}, nil)
+
+// queueCollector reports the queue length of the async writers.
+type queueCollector struct{}
+
+func (queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDesc
+}
+
+func (queueCollector) Collect(ch chan<- prometheus.Metric) {
+	asyncMutex.Lock()
+	defer asyncMutex.Unlock()
+	for name, w := range asyncWriters {
+		ch <- prometheus.MustNewConstMetric(queueDesc, prometheus.GaugeValue, float64(len(w.queue)), name)
+	}
+}
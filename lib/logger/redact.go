@@ -0,0 +1,198 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RedactConf is the [log.redact] section. It masks sensitive values in
+// the business and access logs, which also carry the HTTP client debug
+// output, and in what RedactWriter writes.
+type RedactConf struct {
+	Enable bool `toml:"enable"`
+	// Fields are names whose values are masked, as a field key containing
+	// one, or as "name: value" and "name=value" in text, case-insensitive.
+	// DefaultRedactFields if empty.
+	Fields []string `toml:"fields"`
+	// Patterns are regexps masked wherever they match in text.
+	// DefaultRedactPatterns if empty.
+	Patterns []string `toml:"patterns"`
+	// Replacement of the masked values, "[REDACTED]" by default.
+	Replacement string `toml:"replacement"`
+}
+
+var (
+	DefaultRedactFields = []string{"authorization", "password", "passwd", "token", "secret", "cookie"}
+	// DefaultRedactPatterns match emails and mainland China mobile numbers.
+	DefaultRedactPatterns = []string{
+		`[\w.+-]+@[\w-]+(\.[\w-]+)+`,
+		`(\+?\b86[- ]?|\b)1[3-9]\d{9}\b`,
+	}
+)
+
+// redaction is set by Init when redaction is on.
+var redaction *redactor
+
+type redactor struct {
+	fields      []string
+	named       *regexp.Regexp
+	patterns    []*regexp.Regexp
+	replacement string
+}
+
+func newRedactor(conf RedactConf) (*redactor, error) {
+	if !conf.Enable {
+		return nil, nil
+	}
+	r := &redactor{replacement: conf.Replacement}
+	if r.replacement == "" {
+		r.replacement = "[REDACTED]"
+	}
+	fields := conf.Fields
+	if len(fields) == 0 {
+		fields = DefaultRedactFields
+	}
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		r.fields = append(r.fields, strings.ToLower(f))
+		names[i] = regexp.QuoteMeta(f)
+	}
+	// The name, its quotes and separator, and an auth scheme are kept,
+	// the value up to a quote, space or separator is masked. Quotes
+	// include the backticks (\x60) of SQL names, as in "`password`='x'".
+	r.named = regexp.MustCompile(`(?i)((?:` + strings.Join(names, "|") + `)[\w-]*["'\x60]?\s*[:=]\s*\[?["'\x60]?(?:(?:bearer|basic)\s+)?)[^"'\x60\s&,;\]]+`)
+	patterns := conf.Patterns
+	if len(patterns) == 0 {
+		patterns = DefaultRedactPatterns
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("log redact pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Redacting reports whether redaction is on.
+func Redacting() bool {
+	return redaction != nil
+}
+
+// Redact masks the sensitive values of s, or returns it as is when
+// redaction is off.
+func Redact(s string) string {
+	if redaction == nil {
+		return s
+	}
+	return redaction.text(s)
+}
+
+func (r *redactor) text(s string) string {
+	s = r.named.ReplaceAllString(s, "${1}"+r.replacement)
+	for _, re := range r.patterns {
+		s = re.ReplaceAllLiteralString(s, r.replacement)
+	}
+	return s
+}
+
+func (r *redactor) sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, f := range r.fields {
+		if strings.Contains(key, f) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *redactor) field(f zapcore.Field) zapcore.Field {
+	if r.sensitive(f.Key) {
+		return zap.String(f.Key, r.replacement)
+	}
+	switch f.Type {
+	case zapcore.StringType:
+		f.String = r.text(f.String)
+	case zapcore.ByteStringType:
+		return zap.String(f.Key, r.text(string(f.Interface.([]byte))))
+	case zapcore.StringerType:
+		return zap.String(f.Key, r.text(fmt.Sprint(f.Interface)))
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok && err != nil {
+			return zap.String(f.Key, r.text(err.Error()))
+		}
+	case zapcore.ReflectType:
+		// Maps and structs are masked in their JSON form.
+		if b, err := json.Marshal(f.Interface); err == nil {
+			if masked := r.text(string(b)); masked != string(b) {
+				return zap.Reflect(f.Key, json.RawMessage(masked))
+			}
+		}
+	}
+	return f
+}
+
+func (r *redactor) fieldsOf(fields []zapcore.Field) []zapcore.Field {
+	masked := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		masked[i] = r.field(f)
+	}
+	return masked
+}
+
+// redactCore masks the message and fields of the lines it writes.
+type redactCore struct {
+	zapcore.Core
+	r *redactor
+}
+
+func newRedactCore(core zapcore.Core, r *redactor) zapcore.Core {
+	if r == nil {
+		return core
+	}
+	return redactCore{Core: core, r: r}
+}
+
+func (c redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactCore{Core: c.Core.With(c.r.fieldsOf(fields)), r: c.r}
+}
+
+func (c redactCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c redactCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Message = c.r.text(ent.Message)
+	return c.Core.Write(ent, c.r.fieldsOf(fields))
+}
+
+// RedactWriter masks what is written to w when redaction is on, for
+// loggers outside this package such as GORM's. Each write is masked
+// alone, so it should be a whole line.
+func RedactWriter(w io.Writer) io.Writer {
+	return redactWriter{w}
+}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+func (w redactWriter) Write(p []byte) (int, error) {
+	if redaction == nil {
+		return w.w.Write(p)
+	}
+	if _, err := io.WriteString(w.w, redaction.text(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,26 @@
+package logger
+
+import "testing"
+
+func TestRedactText(t *testing.T) {
+	r, err := newRedactor(RedactConf{Enable: true, Replacement: "***"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"password=hunter2&user=bob", "password=***&user=bob"},
+		{`{"token": "abc123", "user": "bob"}`, `{"token": "***", "user": "bob"}`},
+		{"Authorization: Bearer abc.def", "Authorization: Bearer ***"},
+		{"UPDATE `users` SET `password`='hunter2' WHERE `id` = 1", "UPDATE `users` SET `password`='***' WHERE `id` = 1"},
+		{"SELECT * FROM `sessions` WHERE `token` = 'abc123'", "SELECT * FROM `sessions` WHERE `token` = '***'"},
+		{"UPDATE users SET passwd = \"hunter2\"", "UPDATE users SET passwd = \"***\""},
+		{"mail bob@example.com or call +86 13812345678", "mail *** or call ***"},
+	}
+	for _, tt := range tests {
+		if got := r.text(tt.in); got != tt.want {
+			t.Errorf("text(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+	"go.uber.org/zap/zapcore"
+)
+
+// defaultMaxAge keeps rotated files for a week when neither max_age nor
+// rotation_count is set, as rotatelogs does.
+const defaultMaxAge = 7 * 24 * time.Hour
+
+// newWriteSyncer writes to logFile, a strftime pattern rotated hourly and
+// by size, or to stdout if logFile is "stdout" or empty. cfg.Console
+// mirrors file output to stdout.
+func newWriteSyncer(logFile string, logFileLink string, cfg LoggerConf) (zapcore.WriteSyncer, error) {
+	stdout := zapcore.AddSync(os.Stdout)
+	if logFile == "" || logFile == "stdout" {
+		return stdout, nil
+	}
+	var maxAge time.Duration
+	if cfg.MaxAge != "" {
+		d, err := time.ParseDuration(cfg.MaxAge)
+		if err != nil {
+			return nil, err
+		}
+		maxAge = d
+	}
+	options := []rotatelogs.Option{
+		rotatelogs.WithRotationSize(int64(cfg.Size) * 1024 * 1024 * 1024),
+		rotatelogs.WithRotationTime(time.Hour),
+	}
+	if logFileLink != "" {
+		options = append(options, rotatelogs.WithLinkName(logFileLink))
+	}
+	// rotatelogs takes either a count or an age, the handler enforces the
+	// age when both are set, and both on gzipped files.
+	switch {
+	case cfg.RotationCount > 0:
+		options = append(options, rotatelogs.WithRotationCount(cfg.RotationCount))
+	case maxAge > 0:
+		options = append(options, rotatelogs.WithMaxAge(maxAge))
+	default:
+		options = append(options, rotatelogs.WithMaxAge(defaultMaxAge))
+	}
+	if cfg.Compress || (cfg.RotationCount > 0 && maxAge > 0) {
+		h := &rotatedHandler{glob: globPattern(logFile), compress: cfg.Compress, maxAge: maxAge}
+		if cfg.Compress {
+			// rotatelogs doesn't see the .gz files, so their count and age
+			// are enforced here too.
+			h.count = int(cfg.RotationCount)
+			if h.count == 0 && h.maxAge == 0 {
+				h.maxAge = defaultMaxAge
+			}
+		}
+		options = append(options, rotatelogs.WithHandler(h))
+	}
+	if err := os.MkdirAll(filepath.Dir(logFile), 0755); err != nil {
+		return nil, err
+	}
+	rotator, err := rotatelogs.New(logFile, options...)
+	if err != nil {
+		return nil, err
+	}
+	w := zapcore.AddSync(rotator)
+	if cfg.Console {
+		return zapcore.NewMultiWriteSyncer(w, stdout), nil
+	}
+	return w, nil
+}
+
+var (
+	strftimeVerb = regexp.MustCompile(`%[%+A-Za-z]`)
+	stars        = regexp.MustCompile(`\*+`)
+)
+
+// globPattern matches every file logFile rotates into, as rotatelogs does.
+func globPattern(logFile string) string {
+	return stars.ReplaceAllString(strftimeVerb.ReplaceAllString(logFile, "*"), "*")
+}
+
+// rotatedHandler gzips the file rotated away from and deletes rotated
+// files, gzipped or not, beyond the newest count or older than maxAge.
+type rotatedHandler struct {
+	glob     string
+	compress bool
+	count    int
+	maxAge   time.Duration
+}
+
+func (h *rotatedHandler) Handle(e rotatelogs.Event) {
+	rotated, ok := e.(*rotatelogs.FileRotatedEvent)
+	if !ok {
+		return
+	}
+	go func() {
+		if prev := rotated.PreviousFile(); h.compress && prev != "" {
+			if err := gzipFile(prev); err != nil {
+				log.Printf("[DS]gzip rotated log failed,file=%s,err=%v", prev, err)
+			}
+		}
+		if h.count > 0 || h.maxAge > 0 {
+			h.prune(rotated.CurrentFile(), time.Now())
+		}
+	}()
+}
+
+// prune counts current among the newest files, as rotatelogs does.
+func (h *rotatedHandler) prune(current string, now time.Time) {
+	type rotatedFile struct {
+		path    string
+		modTime time.Time
+	}
+	var files []rotatedFile
+	seen := make(map[string]bool)
+	// A pattern ending in a literal suffix such as ".log" doesn't match
+	// the gzipped files.
+	for _, glob := range []string{h.glob, h.glob + ".gz"} {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return
+		}
+		for _, path := range matches {
+			if seen[path] || path == current || strings.HasSuffix(path, "_lock") || strings.HasSuffix(path, "_symlink") {
+				continue
+			}
+			seen[path] = true
+			if fi, err := os.Lstat(path); err == nil && fi.Mode().IsRegular() {
+				files = append(files, rotatedFile{path: path, modTime: fi.ModTime()})
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	cutoff := now.Add(-h.maxAge)
+	for i, f := range files {
+		if (h.count > 0 && i+1 >= h.count) || (h.maxAge > 0 && f.modTime.Before(cutoff)) {
+			_ = os.Remove(f.path)
+		}
+	}
+}
+
+// gzipFile replaces path with path.gz.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
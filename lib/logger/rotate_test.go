@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRotatedHandlerPrune(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name   string
+		count  int
+		maxAge time.Duration
+		want   []string
+	}{
+		{"count", 3, 0, []string{"biz-10.log", "biz-11.log.gz", "biz-12.log"}},
+		{"age", 0, 90 * time.Minute, []string{"biz-11.log.gz", "biz-12.log"}},
+		{"count and age", 2, 3 * time.Hour, []string{"biz-11.log.gz", "biz-12.log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]time.Duration{
+				"biz-08.log.gz": 4 * time.Hour,
+				"biz-09.log.gz": 3 * time.Hour,
+				"biz-10.log":    2 * time.Hour,
+				"biz-11.log.gz": time.Hour,
+				"biz-12.log":    0,
+			}
+			for name, age := range files {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, []byte("line\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			h := &rotatedHandler{glob: globPattern(filepath.Join(dir, "biz-%H.log")), count: tt.count, maxAge: tt.maxAge}
+			h.prune(filepath.Join(dir, "biz-12.log"), now)
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("kept %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("kept %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
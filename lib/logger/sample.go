@@ -0,0 +1,264 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// SamplingConf is the [log.sampling] section. It thins out the business
+// lines of this package's functions, fatal lines are always written.
+type SamplingConf struct {
+	// First lines of one message and level are written every Tick, then
+	// every Thereafter-th, none if 0. First 0 turns sampling off.
+	First      int    `toml:"first"`
+	Thereafter int    `toml:"thereafter"`
+	Tick       string `toml:"tick"`
+	// Dedup drops a line identical to one written in the current
+	// DedupInterval, and ends the interval with a "suppressed X similar
+	// lines" line for it.
+	Dedup         bool   `toml:"dedup"`
+	DedupInterval string `toml:"dedup_interval"`
+}
+
+// sampler is set by Init when sampling or dedup is on.
+var sampler *lineSampler
+
+// dedupShards splits the dedup lines so lines of different messages
+// rarely wait for one another. maxDuplicates caps the lines remembered
+// per interval, later new lines are written without dedup until the
+// interval ends.
+const (
+	dedupShards   = 16
+	maxDuplicates = 4096
+	// maxExample bounds the example line kept for a summary.
+	maxExample = 512
+)
+
+type lineSampler struct {
+	first, thereafter uint64
+	tick              time.Duration
+	dedup             bool
+	interval          time.Duration
+
+	// counts is keyed by level and message, one per call site, and holds
+	// *sampleCount.
+	counts sync.Map
+	shards [dedupShards]dedupShard
+
+	sampledDrops, duplicateDrops prometheus.Counter
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// sampleCount counts the lines of a message in the current tick. A line
+// racing with the reset may count in either tick.
+type sampleCount struct {
+	resetAt atomic.Int64
+	n       atomic.Uint64
+}
+
+// dedupShard holds the lines written in the current interval, keyed by a
+// hash of level, message and arguments. Lines whose hashes collide count
+// as one.
+type dedupShard struct {
+	mutex      sync.Mutex
+	duplicates map[uint64]*duplicate
+}
+
+type duplicate struct {
+	level zapcore.Level
+	key   string
+	// example is the line as written, with the arguments of a template.
+	example    string
+	suppressed uint64
+}
+
+func newSampler(conf SamplingConf) (*lineSampler, error) {
+	if conf.First <= 0 && !conf.Dedup {
+		return nil, nil
+	}
+	if conf.First < 0 || conf.Thereafter < 0 {
+		return nil, fmt.Errorf("negative log sampling first=%d,thereafter=%d", conf.First, conf.Thereafter)
+	}
+	s := &lineSampler{
+		first:          uint64(conf.First),
+		thereafter:     uint64(conf.Thereafter),
+		tick:           time.Second,
+		dedup:          conf.Dedup,
+		interval:       10 * time.Second,
+		sampledDrops:   droppedLines.WithLabelValues(loggerBusiness, reasonSampled),
+		duplicateDrops: droppedLines.WithLabelValues(loggerBusiness, reasonDuplicate),
+		quit:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	for i := range s.shards {
+		s.shards[i].duplicates = make(map[uint64]*duplicate)
+	}
+	var err error
+	if conf.Tick != "" {
+		if s.tick, err = time.ParseDuration(conf.Tick); err != nil {
+			return nil, err
+		}
+	}
+	if conf.DedupInterval != "" {
+		if s.interval, err = time.ParseDuration(conf.DedupInterval); err != nil {
+			return nil, err
+		}
+	}
+	if s.tick <= 0 || s.interval <= 0 {
+		return nil, fmt.Errorf("log sampling tick=%s,dedup_interval=%s must be positive", s.tick, s.interval)
+	}
+	go s.run()
+	return s, nil
+}
+
+// sampled reports whether a line of c at level l is written. key is the
+// message, so lines of one call site count together whatever their
+// request or arguments.
+func sampled(c context.Context, l zapcore.Level, key string, args []interface{}) bool {
+	return sample(c, l, key, args, false)
+}
+
+// sampledf is sampled for the *f functions, key is their template.
+func sampledf(c context.Context, l zapcore.Level, key string, args []interface{}) bool {
+	return sample(c, l, key, args, true)
+}
+
+func sample(c context.Context, l zapcore.Level, key string, args []interface{}, template bool) bool {
+	s := sampler
+	if s == nil || l >= zapcore.FatalLevel || !bizLogger(c).Desugar().Core().Enabled(l) {
+		return true
+	}
+	return s.allow(l, key, args, template, time.Now())
+}
+
+func (s *lineSampler) allow(l zapcore.Level, key string, args []interface{}, template bool, now time.Time) bool {
+	if s.first > 0 && !s.count(l, key, now) {
+		s.sampledDrops.Inc()
+		return false
+	}
+	if s.dedup && !s.firstInInterval(l, key, args, template) {
+		s.duplicateDrops.Inc()
+		return false
+	}
+	return true
+}
+
+// count counts a line of key at level l and reports whether it is within
+// First or a Thereafter-th one.
+func (s *lineSampler) count(l zapcore.Level, key string, now time.Time) bool {
+	v, ok := s.counts.Load(l.String() + "|" + key)
+	if !ok {
+		v, _ = s.counts.LoadOrStore(l.String()+"|"+key, &sampleCount{})
+	}
+	count := v.(*sampleCount)
+	var n uint64
+	if tn := now.UnixNano(); count.resetAt.Load() > tn {
+		n = count.n.Add(1)
+	} else {
+		count.n.Store(1)
+		count.resetAt.Store(tn + int64(s.tick))
+		n = 1
+	}
+	return n <= s.first || (s.thereafter != 0 && (n-s.first)%s.thereafter == 0)
+}
+
+// firstInInterval reports whether the line is new in this interval,
+// counting it as suppressed otherwise.
+func (s *lineSampler) firstInInterval(l zapcore.Level, key string, args []interface{}, template bool) bool {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte{byte(l)})
+	_, _ = io.WriteString(h, key)
+	if len(args) > 0 {
+		_, _ = fmt.Fprint(h, args...)
+	}
+	line := h.Sum64()
+	shard := &s.shards[line%dedupShards]
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if dup, ok := shard.duplicates[line]; ok {
+		dup.suppressed++
+		return false
+	}
+	if len(shard.duplicates) >= maxDuplicates/dedupShards {
+		return true
+	}
+	dup := &duplicate{level: l, key: key, example: key}
+	if template {
+		dup.example = sprintf(key, args)
+	}
+	if len(dup.example) > maxExample {
+		n := maxExample
+		for n > 0 && !utf8.RuneStart(dup.example[n]) {
+			n--
+		}
+		dup.example = dup.example[:n] + "..."
+	}
+	shard.duplicates[line] = dup
+	return true
+}
+
+// run ends the dedup intervals and forgets messages not seen in a while.
+func (s *lineSampler) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			s.flush(time.Now())
+			return
+		case now := <-ticker.C:
+			s.flush(now)
+		}
+	}
+}
+
+func (s *lineSampler) flush(now time.Time) {
+	s.counts.Range(func(key, v interface{}) bool {
+		if now.UnixNano() > v.(*sampleCount).resetAt.Load() {
+			s.counts.Delete(key)
+		}
+		return true
+	})
+	var duplicates []*duplicate
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mutex.Lock()
+		for _, dup := range shard.duplicates {
+			duplicates = append(duplicates, dup)
+		}
+		shard.duplicates = make(map[uint64]*duplicate)
+		shard.mutex.Unlock()
+	}
+	var fields []zap.Field
+	if structured {
+		fields = append(fields, zap.String("scope", scopeBackground))
+	}
+	// Summaries are info lines whatever the level of the suppressed ones,
+	// so they don't count as errors. "level" is taken by the entry's own.
+	summary := blogger.Desugar().WithOptions(zap.WithCaller(false))
+	for _, dup := range duplicates {
+		if dup.suppressed > 0 {
+			summary.Info(notCtxMsg(fmt.Sprintf("suppressed %d similar lines|%s", dup.suppressed, dup.example)),
+				append(fields, zap.String("suppressed_level", dup.level.String()), zap.String("key", dup.key))...)
+		}
+	}
+}
+
+// Stop writes the pending summaries and stops the interval timer.
+func (s *lineSampler) Stop() {
+	close(s.quit)
+	<-s.done
+}
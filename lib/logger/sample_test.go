@@ -0,0 +1,144 @@
+package logger
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDedupSummary(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	old := blogger
+	blogger = zap.New(core).Sugar()
+	defer func() { blogger = old }()
+	s, err := newSampler(SamplingConf{Dedup: true, DedupInterval: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	now := time.Now()
+	args := []interface{}{3}
+	for i, want := range []bool{true, false, false} {
+		if got := s.allow(zapcore.ErrorLevel, "retry %d failed", args, true, now); got != want {
+			t.Fatalf("line %d allowed=%v, want %v", i, got, want)
+		}
+	}
+	s.flush(now)
+
+	entries := logs.AllUntimed()
+	if len(entries) != 1 {
+		t.Fatalf("got %d summaries, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Level != zapcore.InfoLevel {
+		t.Errorf("summary at %s, want info", e.Level)
+	}
+	if want := notCtxMsg("suppressed 2 similar lines|retry 3 failed"); e.Message != want {
+		t.Errorf("summary %q, want %q", e.Message, want)
+	}
+	fields := e.ContextMap()
+	if fields["suppressed_level"] != "error" || fields["key"] != "retry %d failed" {
+		t.Errorf("summary fields %v", fields)
+	}
+}
+
+func TestSampleFirstThereafter(t *testing.T) {
+	old := blogger
+	blogger = zap.NewNop().Sugar()
+	defer func() { blogger = old }()
+	s, err := newSampler(SamplingConf{First: 2, Thereafter: 3, Tick: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	now := time.Now()
+	var got []bool
+	for i := 0; i < 8; i++ {
+		got = append(got, s.allow(zapcore.InfoLevel, "polled", nil, false, now))
+	}
+	want := []bool{true, true, false, false, true, false, false, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("allowed %v, want %v", got, want)
+		}
+	}
+	// Other messages and levels count apart, and the count restarts
+	// with the next tick.
+	if !s.allow(zapcore.WarnLevel, "polled", nil, false, now) || !s.allow(zapcore.InfoLevel, "other", nil, false, now) {
+		t.Error("another level or message was sampled")
+	}
+	if !s.allow(zapcore.InfoLevel, "polled", nil, false, now.Add(2*time.Hour)) {
+		t.Error("first line of the next tick was sampled")
+	}
+}
+
+func TestDedupIsBounded(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	old := blogger
+	blogger = zap.New(core).Sugar()
+	defer func() { blogger = old }()
+	s, err := newSampler(SamplingConf{Dedup: true, DedupInterval: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	now := time.Now()
+	for i := 0; i < 2*maxDuplicates; i++ {
+		if !s.allow(zapcore.ErrorLevel, "user %d failed", []interface{}{i}, true, now) {
+			t.Fatalf("new line %d was suppressed", i)
+		}
+	}
+	remembered := 0
+	for i := range s.shards {
+		remembered += len(s.shards[i].duplicates)
+	}
+	if remembered > maxDuplicates {
+		t.Errorf("%d lines remembered, want at most %d", remembered, maxDuplicates)
+	}
+
+	s.flush(now)
+	logs.TakeAll()
+
+	long := strings.Repeat("é", maxExample)
+	for i := 0; i < 2; i++ {
+		s.allow(zapcore.ErrorLevel, "%s", []interface{}{long}, true, now)
+	}
+	s.flush(now)
+	for _, e := range logs.AllUntimed() {
+		if strings.Contains(e.Message, "é") {
+			if len(e.Message) > maxExample+100 || !utf8.ValidString(e.Message) {
+				t.Errorf("example of %d bytes, valid UTF-8 %v", len(e.Message), utf8.ValidString(e.Message))
+			}
+			return
+		}
+	}
+	t.Error("no summary for the repeated long line")
+}
+
+// BenchmarkAllow logs lines of a few messages from every goroutine.
+func BenchmarkAllow(b *testing.B) {
+	old := blogger
+	blogger = zap.NewNop().Sugar()
+	defer func() { blogger = old }()
+	s, err := newSampler(SamplingConf{First: 100, Thereafter: 100, Dedup: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Stop()
+	keys := []string{"a %d", "b %d", "c %d", "d %d"}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.allow(zapcore.InfoLevel, keys[i%len(keys)], []interface{}{i % 64}, true, time.Now())
+			i++
+		}
+	})
+}
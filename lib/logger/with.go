@@ -0,0 +1,36 @@
+package logger
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fieldsKey holds the key-value pairs attached by With.
+const fieldsKey = "log_fields"
+
+// With attaches key-value pairs to c, logged by every later line of c
+// and by its access line. A *gin.Context is changed in place and
+// returned, other contexts are wrapped.
+func With(c context.Context, args ...interface{}) context.Context {
+	if c == nil || len(args) == 0 {
+		return c
+	}
+	prev := Fields(c)
+	fields := make([]interface{}, 0, len(prev)+len(args))
+	fields = append(append(fields, prev...), args...)
+	if gc, ok := c.(*gin.Context); ok {
+		gc.Set(fieldsKey, fields)
+		return gc
+	}
+	return context.WithValue(c, fieldsKey, fields)
+}
+
+// Fields returns the key-value pairs attached to c by With.
+func Fields(c context.Context) []interface{} {
+	if c == nil {
+		return nil
+	}
+	fields, _ := c.Value(fieldsKey).([]interface{})
+	return fields
+}
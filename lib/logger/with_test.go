@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestWithFieldsInBusinessAndAccessLines(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	oldBiz, oldAccess, oldStructured := blogger, alogger, structured
+	blogger, alogger = zap.New(core).Sugar(), zap.New(core).Sugar()
+	defer func() { blogger, alogger, structured = oldBiz, oldAccess, oldStructured }()
+
+	gin.SetMode(gin.TestMode)
+	ginContext := func() context.Context {
+		gc, _ := gin.CreateTestContext(httptest.NewRecorder())
+		return gc
+	}
+	for _, tt := range []struct {
+		name       string
+		ctx        context.Context
+		structured bool
+	}{
+		{"context console", context.Background(), false},
+		{"context json", context.Background(), true},
+		{"gin console", ginContext(), false},
+		{"gin json", ginContext(), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			structured = tt.structured
+			logs.TakeAll()
+			ctx := With(tt.ctx, "user", "u1")
+			ctx = With(ctx, "order", 42)
+			Info(ctx, "paid")
+			Errorf(ctx, "refund %d failed", 7)
+			AccessInfo(ctx, "access", "status", 200)
+
+			entries := logs.TakeAll()
+			if len(entries) != 3 {
+				t.Fatalf("got %d lines, want 3", len(entries))
+			}
+			for _, e := range entries {
+				fields := e.ContextMap()
+				if fields["user"] != "u1" || fields["order"] != int64(42) {
+					t.Errorf("%q: fields %v, want user=u1 order=42", e.Message, fields)
+				}
+			}
+			if status := entries[2].ContextMap()["status"]; status != int64(200) {
+				t.Errorf("access line lost its own args: status=%v", status)
+			}
+		})
+	}
+	if fields := Fields(context.Background()); fields != nil {
+		t.Errorf("Fields of a bare context = %v, want nil", fields)
+	}
+}
@@ -0,0 +1,202 @@
+package prometheus
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// OverflowValue replaces every label value of a label set that would push
+// a metric over its series limit.
+const OverflowValue = "__overflow__"
+
+// DefaultMaxSeries caps the label sets of a metric when neither Conf nor
+// MetricConf sets max_series. A negative max_series disables the cap.
+const DefaultMaxSeries = 1000
+
+// overflowMetricName counts updates folded into OverflowValue, by metric.
+// It counts updates, not label sets: one label set refused by the limit
+// counts once per update.
+const overflowMetricName = "series_overflow_updates_total"
+
+type cardinalityGuard struct {
+	limit int64
+	count atomic.Int64
+	seen  sync.Map
+}
+
+func newCardinalityGuard(limit int) *cardinalityGuard {
+	return &cardinalityGuard{limit: int64(limit)}
+}
+
+// admit reports whether the label set key may get its own series,
+// remembering it if so. Known label sets, the common case, are admitted
+// without locking.
+func (g *cardinalityGuard) admit(key string) bool {
+	if g.limit < 0 {
+		return true
+	}
+	if _, ok := g.seen.Load(key); ok {
+		return true
+	}
+	for {
+		n := g.count.Load()
+		if n >= g.limit {
+			return false
+		}
+		if g.count.CompareAndSwap(n, n+1) {
+			break
+		}
+	}
+	if _, loaded := g.seen.LoadOrStore(key, struct{}{}); loaded {
+		g.count.Add(-1)
+	}
+	return true
+}
+
+// forget frees the slot of an expired label set. The folded overflow label
+// set never took a slot.
+func (g *cardinalityGuard) forget(key string, overflow bool) {
+	if _, loaded := g.seen.LoadAndDelete(key); loaded && !overflow && g.limit >= 0 {
+		g.count.Add(-1)
+	}
+}
+
+// seriesKey identifies a label set by its values in label order, the same
+// way for label maps and positional values.
+func seriesKey(values []string) string {
+	var b strings.Builder
+	for _, v := range values {
+		b.WriteString(v)
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
+
+func (m *metric) mapValues(kv map[string]string) []string {
+	values := make([]string, len(m.labels))
+	for i, l := range m.labels {
+		values[i] = kv[l]
+	}
+	return values
+}
+
+func (m *metric) mapKey(kv map[string]string) string {
+	return seriesKey(m.mapValues(kv))
+}
+
+func (m *metric) overflowKey() string {
+	values := make([]string, len(m.labels))
+	for i := range values {
+		values[i] = OverflowValue
+	}
+	return seriesKey(values)
+}
+
+func (pI *Registry) maxSeries(name string) int {
+	if name == overflowMetricName || name == expiredMetricName {
+		return -1
+	}
+	if mc, ok := pI.conf.Metric[name]; ok && mc.MaxSeries != 0 {
+		return mc.MaxSeries
+	}
+	if pI.conf.MaxSeries != 0 {
+		return pI.conf.MaxSeries
+	}
+	return DefaultMaxSeries
+}
+
+// checkLabels fails unless kv has exactly the labels of m, with UTF-8
+// values, so a label set the vector would refuse takes no slot.
+func (m *metric) checkLabels(kv map[string]string) error {
+	if len(kv) != len(m.labels) {
+		return fmt.Errorf("%d labels given, want %v", len(kv), m.labels)
+	}
+	for _, l := range m.labels {
+		v, ok := kv[l]
+		if !ok {
+			return fmt.Errorf("label %q missing, want %v", l, m.labels)
+		}
+		if !utf8.ValidString(v) {
+			return fmt.Errorf("label %q value %q is not valid UTF-8", l, v)
+		}
+	}
+	return nil
+}
+
+// checkValues is checkLabels for positional label values.
+func (m *metric) checkValues(values []string) error {
+	if len(values) != len(m.labels) {
+		return fmt.Errorf("%d label values given, want %v", len(values), m.labels)
+	}
+	for i, v := range values {
+		if !utf8.ValidString(v) {
+			return fmt.Errorf("label %q value %q is not valid UTF-8", m.labels[i], v)
+		}
+	}
+	return nil
+}
+
+// guard returns kv, or a copy with every value set to OverflowValue when
+// m is at its series limit. kv itself is never modified. The returned
+// label set is recorded as updated for TTL expiry. A kv that doesn't fit
+// the labels of m is refused.
+func (pI *Registry) guard(name string, m *metric, kv map[string]string) (map[string]string, error) {
+	if err := m.checkLabels(kv); err != nil {
+		return nil, err
+	}
+	if m.ttl != nil {
+		m.ttl.mutex.RLock()
+		defer m.ttl.mutex.RUnlock()
+	}
+	key := m.mapKey(kv)
+	if !m.guard.admit(key) {
+		folded := make(map[string]string, len(kv))
+		for k := range kv {
+			folded[k] = OverflowValue
+		}
+		key = pI.overflow(name, m)
+		kv = folded
+	}
+	if m.ttl != nil {
+		m.ttl.touch(key, func() []string { return m.mapValues(kv) })
+	}
+	return kv, nil
+}
+
+// guardValues is guard for positional label values.
+func (pI *Registry) guardValues(name string, m *metric, values []string) ([]string, error) {
+	if err := m.checkValues(values); err != nil {
+		return nil, err
+	}
+	if m.ttl != nil {
+		m.ttl.mutex.RLock()
+		defer m.ttl.mutex.RUnlock()
+	}
+	key := seriesKey(values)
+	if !m.guard.admit(key) {
+		folded := make([]string, len(values))
+		for i := range folded {
+			folded[i] = OverflowValue
+		}
+		key = pI.overflow(name, m)
+		values = folded
+	}
+	if m.ttl != nil {
+		m.ttl.touch(key, func() []string { return append([]string(nil), values...) })
+	}
+	return values, nil
+}
+
+// overflow counts a folded update and returns the key of the folded label
+// set, which always gets a series, even past the limit.
+func (pI *Registry) overflow(name string, m *metric) string {
+	key := m.overflowKey()
+	m.guard.seen.Store(key, struct{}{})
+	if overflow, ok := pI.lookup(overflowMetricName); ok {
+		_ = overflow.addQps(map[string]string{"metric": name}, 1)
+	}
+	return key
+}
@@ -0,0 +1,168 @@
+package prometheus
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	KindHistogram = "histogram"
+	KindSummary   = "summary"
+	// KindCompat emits a histogram under the metric name and a summary
+	// under "<name>_summary", for one migration window.
+	KindCompat = "compat"
+)
+
+var DefaultObjectives = map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}
+
+// DefaultNamespace prefixes metric names unless Conf.Namespace is set.
+const DefaultNamespace = "dispatcher"
+
+// Conf is the [metrics] section of the config file.
+type Conf struct {
+	Namespace string `toml:"namespace"`
+	// Subsystem defaults to the server name.
+	Subsystem string `toml:"subsystem"`
+	// Labels are added to every series, e.g. cluster, env and zone.
+	Labels map[string]string `toml:"labels"`
+	// TargetInfo moves the ip and idc labels off every series into a
+	// single target_info gauge.
+	TargetInfo bool `toml:"target_info"`
+	// OpenMetrics lets scrapers negotiate the OpenMetrics format, the only
+	// one exposing exemplars. Counters not named "*_total" are then typed
+	// unknown, so it stays off until they are.
+	OpenMetrics bool `toml:"open_metrics"`
+	// MaxSeries caps the label sets of every metric, see DefaultMaxSeries.
+	MaxSeries int                   `toml:"max_series"`
+	Metric    map[string]MetricConf `toml:"metric"`
+	// SLO feeds the alerts generated by Rules, keyed by metric name.
+	SLO  map[string]SLOConf `toml:"slo"`
+	Push PushConf           `toml:"push"`
+	// Sinks lists where updates go, SinkPrometheus and/or SinkStatsD.
+	// Empty means Prometheus only.
+	Sinks  []string   `toml:"sinks"`
+	StatsD StatsDConf `toml:"statsd"`
+}
+
+// MetricConf tunes one metric, keyed by its name. Empty fields keep the
+// values given at registration. Only Help, MaxSeries and TTL apply to
+// counters and gauges.
+type MetricConf struct {
+	Help      string `toml:"help"`
+	MaxSeries int    `toml:"max_series"`
+	// TTL deletes series not updated for that long, e.g. "1h".
+	TTL        string             `toml:"ttl"`
+	Kind       string             `toml:"type"`
+	Buckets    []float64          `toml:"buckets"`
+	Objectives map[string]float64 `toml:"objectives"`
+	MaxAge     string             `toml:"max_age"`
+	// NativeBucketFactor > 1 turns on native (sparse) histograms next to
+	// the classic buckets.
+	NativeBucketFactor float64 `toml:"native_bucket_factor"`
+	NativeMaxBuckets   uint32  `toml:"native_max_buckets"`
+}
+
+func (c Conf) namespace() string {
+	if c.Namespace != "" {
+		return c.Namespace
+	}
+	return DefaultNamespace
+}
+
+func (c Conf) subsystem(serverName string) string {
+	if c.Subsystem != "" {
+		return c.Subsystem
+	}
+	return serverName
+}
+
+// Validate checks the values of c that are parsed when metrics are
+// registered.
+func (c Conf) Validate() error {
+	for name, mc := range c.Metric {
+		if err := mc.validate(); err != nil {
+			return fmt.Errorf("metric %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (mc MetricConf) validate() error {
+	switch mc.Kind {
+	case "", KindHistogram, KindSummary, KindCompat:
+	default:
+		return fmt.Errorf("unknown type %q", mc.Kind)
+	}
+	for i := 1; i < len(mc.Buckets); i++ {
+		if mc.Buckets[i] <= mc.Buckets[i-1] {
+			return fmt.Errorf("buckets %v are not increasing", mc.Buckets)
+		}
+	}
+	for q, e := range mc.Objectives {
+		quantile, err := strconv.ParseFloat(q, 64)
+		if err != nil || quantile < 0 || quantile > 1 {
+			return fmt.Errorf("bad objective quantile %q", q)
+		}
+		if e < 0 || e >= 1 {
+			return fmt.Errorf("bad objective error %v of quantile %q", e, q)
+		}
+	}
+	for key, value := range map[string]string{"max_age": mc.MaxAge, "ttl": mc.TTL} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("bad %s %q", key, value)
+		}
+	}
+	return nil
+}
+
+// kind, objectives and applySummary expect mc to be validated.
+func (mc MetricConf) kind(pType Type) string {
+	if mc.Kind != "" {
+		return mc.Kind
+	}
+	if pType == TypeSummary {
+		return KindSummary
+	}
+	return KindHistogram
+}
+
+func (mc MetricConf) applyHistogram(opts *prometheus.HistogramOpts, bulks []float64) {
+	switch {
+	case mc.Buckets != nil:
+		opts.Buckets = mc.Buckets
+	case bulks != nil:
+		opts.Buckets = bulks
+	default:
+		opts.Buckets = DefaultBuckets
+	}
+	if mc.NativeBucketFactor > 1 {
+		opts.NativeHistogramBucketFactor = mc.NativeBucketFactor
+		opts.NativeHistogramMaxBucketNumber = mc.NativeMaxBuckets
+		opts.NativeHistogramMinResetDuration = time.Hour
+	}
+}
+
+func (mc MetricConf) objectives() map[float64]float64 {
+	if mc.Objectives == nil {
+		return DefaultObjectives
+	}
+	objectives := make(map[float64]float64, len(mc.Objectives))
+	for q, e := range mc.Objectives {
+		quantile, _ := strconv.ParseFloat(q, 64)
+		objectives[quantile] = e
+	}
+	return objectives
+}
+
+func (mc MetricConf) applySummary(opts *prometheus.SummaryOpts) {
+	opts.Objectives = mc.objectives()
+	if mc.MaxAge != "" {
+		opts.MaxAge, _ = time.ParseDuration(mc.MaxAge)
+	}
+}
@@ -0,0 +1,51 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		mc      MetricConf
+		wantErr string
+	}{
+		{"empty", MetricConf{}, ""},
+		{"valid", MetricConf{
+			Kind:       KindSummary,
+			Buckets:    []float64{1, 10, 100},
+			Objectives: map[string]float64{"0.5": 0.05, "0.99": 0.001},
+			MaxAge:     "10m",
+			TTL:        "1h",
+		}, ""},
+		{"unknown kind", MetricConf{Kind: "gauge"}, `unknown type "gauge"`},
+		{"decreasing buckets", MetricConf{Buckets: []float64{10, 1}}, "not increasing"},
+		{"repeated bucket", MetricConf{Buckets: []float64{1, 1}}, "not increasing"},
+		{"quantile not a number", MetricConf{Objectives: map[string]float64{"p99": 0.01}}, `bad objective quantile "p99"`},
+		{"quantile above 1", MetricConf{Objectives: map[string]float64{"1.5": 0.01}}, `bad objective quantile "1.5"`},
+		{"objective error 1", MetricConf{Objectives: map[string]float64{"0.9": 1}}, "bad objective error"},
+		{"negative objective error", MetricConf{Objectives: map[string]float64{"0.9": -0.1}}, "bad objective error"},
+		{"bad ttl", MetricConf{TTL: "1 hour"}, `bad ttl "1 hour"`},
+		{"zero ttl", MetricConf{TTL: "0s"}, `bad ttl "0s"`},
+		{"negative max_age", MetricConf{MaxAge: "-1m"}, `bad max_age "-1m"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Conf{Metric: map[string]MetricConf{"latency": tt.mc}}.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			case err != nil && !strings.Contains(err.Error(), `metric "latency"`):
+				t.Errorf("Validate() = %v, does not name the metric", err)
+			}
+		})
+	}
+}
+
+func TestNewRegistryRejectsBadConf(t *testing.T) {
+	if _, err := NewRegistry("test", "test", Conf{Metric: map[string]MetricConf{"latency": {TTL: "soon"}}}); err == nil {
+		t.Error("NewRegistry accepted a bad ttl")
+	}
+}
@@ -0,0 +1,151 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Grafana dashboard model, only the fields the generated dashboard sets.
+type dashboard struct {
+	UID           string     `json:"uid"`
+	Title         string     `json:"title"`
+	Tags          []string   `json:"tags"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Refresh       string     `json:"refresh"`
+	Time          timeRange  `json:"time"`
+	Templating    templating `json:"templating"`
+	Panels        []panel    `json:"panels"`
+}
+
+type timeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type templating struct {
+	List []variable `json:"list"`
+}
+
+type variable struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+type panel struct {
+	ID          int         `json:"id"`
+	Type        string      `json:"type"`
+	Title       string      `json:"title"`
+	Description string      `json:"description,omitempty"`
+	Datasource  datasource  `json:"datasource"`
+	GridPos     gridPos     `json:"gridPos"`
+	FieldConfig fieldConfig `json:"fieldConfig"`
+	Targets     []target    `json:"targets"`
+}
+
+type datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type fieldConfig struct {
+	Defaults struct {
+		Unit string `json:"unit,omitempty"`
+	} `json:"defaults"`
+}
+
+type target struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+}
+
+// Dashboard renders a Grafana dashboard with a panel per metric of defs,
+// as registered by serverName under c. Panels query the recording rules
+// of Rules where there are any.
+func (c Conf) Dashboard(serverName string, defs []Definition) ([]byte, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	subsystem := c.subsystem(serverName)
+	d := dashboard{
+		UID:           c.namespace() + "-" + subsystem,
+		Title:         c.namespace() + " / " + subsystem,
+		Tags:          []string{c.namespace(), "generated"},
+		SchemaVersion: 38,
+		Refresh:       "1m",
+		Time:          timeRange{From: "now-6h", To: "now"},
+		Templating: templating{List: []variable{{
+			Name:  "datasource",
+			Label: "Data source",
+			Type:  "datasource",
+			Query: "prometheus",
+		}}},
+	}
+	for i, def := range defs {
+		p := panel{
+			ID:          i + 1,
+			Type:        "timeseries",
+			Title:       def.Name,
+			Description: def.Help,
+			Datasource:  datasource{Type: "prometheus", UID: "${datasource}"},
+			GridPos:     gridPos{H: 8, W: 12, X: i % 2 * 12, Y: i / 2 * 8},
+			Targets:     c.targets(serverName, def),
+		}
+		if def.Type == TypeQPS {
+			p.FieldConfig.Defaults.Unit = "reqps"
+		} else if def.Type != TypeGauge {
+			p.FieldConfig.Defaults.Unit = def.Unit
+		}
+		d.Panels = append(d.Panels, p)
+	}
+	return json.MarshalIndent(d, "", "  ")
+}
+
+func (c Conf) targets(serverName string, d Definition) []target {
+	full := c.fullName(serverName, d.Name)
+	legend := legendFormat(d.Labels)
+	rate := "rate" + rateWindow
+	switch d.Type {
+	case TypeQPS:
+		return []target{{RefID: "A", Expr: recordName(d.Labels, full, rate), LegendFormat: legend}}
+	case TypeGauge:
+		return []target{{RefID: "A", Expr: fmt.Sprintf("sum%s (%s)", by(d.Labels), full), LegendFormat: legend}}
+	}
+	mc := c.Metric[d.Name]
+	if mc.kind(d.Type) == KindSummary {
+		// Summary quantiles can't be aggregated, show the worst instance.
+		return []target{{
+			RefID:        "A",
+			Expr:         fmt.Sprintf("max%s (%s)", by(d.Labels, []string{"quantile"}), full),
+			LegendFormat: strings.TrimSpace(legend + " q{{quantile}}"),
+		}}
+	}
+	var targets []target
+	for i, q := range mc.quantiles() {
+		targets = append(targets, target{
+			RefID:        string(rune('A' + i)),
+			Expr:         recordName(d.Labels, full, quantileName(q)+"_"+rate),
+			LegendFormat: strings.TrimSpace(legend + " " + quantileName(q)),
+		})
+	}
+	return targets
+}
+
+// legendFormat shows every label of a series, e.g. "{{interface}} {{status}}".
+func legendFormat(labels []string) string {
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = "{{" + l + "}}"
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,70 @@
+package prometheus
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func goldenConf() Conf {
+	return Conf{
+		Labels: map[string]string{"cluster": "c1"},
+		Metric: map[string]MetricConf{
+			"interface":  {Kind: KindHistogram, Buckets: []float64{10, 100, 500, 1000}},
+			"dependence": {Kind: KindCompat, Objectives: map[string]float64{"0.5": 0.05, "0.99": 0.001}},
+			"statistics": {Kind: KindSummary},
+		},
+		SLO: map[string]SLOConf{
+			"interface":  {Objective: 0.999, Errors: `status=~"5.."`, Latency: 500, LatencyObjective: 0.99},
+			"dependence": {Objective: 0.99, Errors: `status="1"`},
+		},
+	}
+}
+
+var goldenDefinitions = []Definition{
+	{Name: "interface", Type: TypeTotal, Help: "Latency of served HTTP requests in milliseconds.", Labels: []string{"interface", "status"}, Unit: "ms"},
+	{Name: "interface_qps", Type: TypeQPS, Help: "Served HTTP requests.", Labels: []string{"interface", "status"}},
+	{Name: "dependence", Type: TypeTotal, Help: "Latency of calls to downstream services in milliseconds.", Labels: []string{"dependence_service", "function", "status"}, Unit: "ms"},
+	{Name: "statistics", Type: TypeSummary, Help: "Sizes of processed batches.", Labels: []string{"module"}},
+	{Name: "connections", Type: TypeGauge, Help: "Open connections.", Labels: []string{"pool"}},
+}
+
+// checkGolden compares got with testdata/name, or rewrites it with
+// -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs, rerun with -update if the change is intended:\n%s", path, got)
+	}
+}
+
+func TestRulesGolden(t *testing.T) {
+	got, err := goldenConf().Rules("xx_server", goldenDefinitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "rules.golden", got)
+}
+
+func TestDashboardGolden(t *testing.T) {
+	got, err := goldenConf().Dashboard("xx_server", goldenDefinitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "dashboard.golden", got)
+}
@@ -0,0 +1,121 @@
+package prometheus
+
+import (
+	"context"
+)
+
+// Definition declares a metric. Definitions are usually written in a TOML
+// file and turned into typed handles by metricsgen.
+type Definition struct {
+	Name    string
+	Type    Type
+	Help    string
+	Labels  []string
+	Buckets []float64
+	// Unit is the unit of observed values, "ms" or "s", if any.
+	Unit string
+}
+
+// Handle addresses a registered metric by label values given in the order
+// of Definition.Labels. Generated typed handles wrap it, so the compiler
+// checks the number of values.
+type Handle struct {
+	reg  *Registry
+	name string
+	m    *metric
+}
+
+// MustRegisterDefinition registers d and returns its handle. It panics if
+// d.Name is already registered with another type or other labels.
+func (pI *Registry) MustRegisterDefinition(d Definition) *Handle {
+	switch d.Type {
+	case TypeQPS:
+		pI.registeQps(d.Name, d.Help, d.Labels)
+	case TypeTotal, TypeSummary:
+		pI.registeObserver(d.Type, d.Name, d.Help, d.Labels, d.Buckets)
+	case TypeGauge:
+		pI.registeGauge(d.Name, d.Help, d.Labels)
+	}
+	m, _ := pI.lookup(d.Name)
+	return &Handle{reg: pI, name: d.Name, m: m}
+}
+
+// Add adds value to a counter or gauge. It fails if the number of values
+// does not match the labels, or if value is negative for a counter.
+func (h *Handle) Add(value float64, values ...string) error {
+	if h.m.qps != nil && value < 0 {
+		return errNegativeCount
+	}
+	values, err := h.reg.guardValues(h.name, h.m, values)
+	if err != nil {
+		return err
+	}
+	if h.m.qps != nil {
+		h.emit(TypeQPS, value, values, true)
+	} else {
+		h.emit(TypeGauge, value, values, true)
+	}
+	if !h.reg.prom {
+		return nil
+	}
+	switch {
+	case h.m.qps != nil:
+		counter, err := h.m.qps.GetMetricWithLabelValues(values...)
+		if err != nil {
+			return err
+		}
+		counter.Add(value)
+	case h.m.gauge != nil:
+		gauge, err := h.m.gauge.GetMetricWithLabelValues(values...)
+		if err != nil {
+			return err
+		}
+		gauge.Add(value)
+	}
+	return nil
+}
+
+// Set sets a gauge.
+func (h *Handle) Set(value float64, values ...string) error {
+	values, err := h.reg.guardValues(h.name, h.m, values)
+	if err != nil {
+		return err
+	}
+	h.emit(TypeGauge, value, values, false)
+	if !h.reg.prom || h.m.gauge == nil {
+		return nil
+	}
+	gauge, err := h.m.gauge.GetMetricWithLabelValues(values...)
+	if err != nil {
+		return err
+	}
+	gauge.Set(value)
+	return nil
+}
+
+// Observe feeds a histogram and/or summary, with an exemplar from ctx.
+func (h *Handle) Observe(ctx context.Context, value float64, values ...string) error {
+	values, err := h.reg.guardValues(h.name, h.m, values)
+	if err != nil {
+		return err
+	}
+	h.emit(TypeTotal, value, values, false)
+	if !h.reg.prom {
+		return nil
+	}
+	if h.m.total != nil {
+		observer, err := h.m.total.GetMetricWithLabelValues(values...)
+		if err != nil {
+			return err
+		}
+		observeWithExemplar(observer, value, exemplarLabels(ctx))
+	}
+	if h.m.summary != nil {
+		observer, err := h.m.summary.GetMetricWithLabelValues(values...)
+		if err != nil {
+			return err
+		}
+		observer.Observe(value)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+// Command metricsgen turns a TOML file of metric definitions into typed
+// handles, so label arguments are checked by the compiler:
+//
+//	//go:generate go run prometheus-test/lib/promethues/metricsgen -in definitions.toml -out metrics_gen.go -pkg metrics
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+)
+
+type definitions struct {
+	Metric []definition `toml:"metric"`
+}
+
+type definition struct {
+	Name    string    `toml:"name"`
+	Var     string    `toml:"var"`
+	Type    string    `toml:"type"`
+	Help    string    `toml:"help"`
+	Labels  []string  `toml:"labels"`
+	Buckets []float64 `toml:"buckets"`
+	// Unit is "ms" or "s" and adds ObserveDuration to histograms and
+	// summaries.
+	Unit string `toml:"unit"`
+}
+
+var types = map[string]string{
+	"counter":   "TypeQPS",
+	"histogram": "TypeTotal",
+	"summary":   "TypeSummary",
+	"gauge":     "TypeGauge",
+}
+
+var (
+	in  = flag.String("in", "definitions.toml", "metric definitions")
+	out = flag.String("out", "metrics_gen.go", "generated file")
+	pkg = flag.String("pkg", "metrics", "package of the generated file")
+)
+
+func main() {
+	flag.Parse()
+	var defs definitions
+	if _, err := toml.DecodeFile(*in, &defs); err != nil {
+		log.Fatalf("metricsgen: %v", err)
+	}
+	src, err := generate(*pkg, filepath.Base(*in), defs.Metric)
+	if err != nil {
+		log.Fatalf("metricsgen: %v", err)
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatalf("metricsgen: %v", err)
+	}
+}
+
+type metricData struct {
+	definition
+	GoType  string
+	VarName string
+	Params  []string
+	Buckets string
+}
+
+func generate(pkgName string, source string, defs []definition) ([]byte, error) {
+	seen := make(map[string]bool)
+	data := make([]metricData, 0, len(defs))
+	for _, d := range defs {
+		if d.Name == "" || seen[d.Name] {
+			return nil, fmt.Errorf("metric name %q is empty or declared twice", d.Name)
+		}
+		seen[d.Name] = true
+		goType, ok := types[d.Type]
+		if !ok {
+			return nil, fmt.Errorf("metric %q: unknown type %q", d.Name, d.Type)
+		}
+		if d.Unit != "" && d.Unit != "ms" && d.Unit != "s" {
+			return nil, fmt.Errorf("metric %q: unknown unit %q", d.Name, d.Unit)
+		}
+		md := metricData{definition: d, GoType: goType, VarName: d.Var}
+		if md.VarName == "" {
+			md.VarName = exported(d.Name)
+		}
+		for _, l := range d.Labels {
+			md.Params = append(md.Params, param(l))
+		}
+		if d.Buckets != nil {
+			md.Buckets = strings.Trim(strings.Join(strings.Fields(fmt.Sprint(d.Buckets)), ", "), "[]")
+		}
+		data = append(data, md)
+	}
+	needContext, needTime := false, false
+	for _, md := range data {
+		if md.Type == "histogram" || md.Type == "summary" {
+			needContext = true
+			needTime = needTime || md.Unit != ""
+		}
+	}
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, map[string]interface{}{
+		"Package":     pkgName,
+		"Source":      source,
+		"Metrics":     data,
+		"NeedContext": needContext,
+		"NeedTime":    needTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// exported turns a metric name such as "interface_qps" into "InterfaceQps".
+func exported(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '_' || r == '-' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// param turns a label name into a parameter name that is not a keyword.
+func param(label string) string {
+	p := exported(label)
+	p = strings.ToLower(p[:1]) + p[1:]
+	if token.IsKeyword(p) {
+		p += "Value"
+	}
+	return p
+}
+
+var tmpl = template.Must(template.New("gen").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`// Code generated by metricsgen from {{.Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .NeedContext}}
+	"context"
+{{- end}}
+{{- if .NeedTime}}
+	"time"
+{{- end}}
+
+	prometheus "prometheus-test/lib/promethues"
+)
+
+// Definitions lists every metric declared in {{.Source}}.
+var Definitions = []prometheus.Definition{
+{{- range .Metrics}}
+	{
+		Name:   {{printf "%q" .Name}},
+		Type:   prometheus.{{.GoType}},
+		Help:   {{printf "%q" .Help}},
+		Labels: []string{ {{- range $i, $l := .Labels}}{{if $i}}, {{end}}{{printf "%q" $l}}{{end -}} },
+		{{- if .Buckets}}
+		Buckets: []float64{ {{- .Buckets -}} },
+		{{- end}}
+		{{- if .Unit}}
+		Unit: {{printf "%q" .Unit}},
+		{{- end}}
+	},
+{{- end}}
+}
+
+var (
+{{- range .Metrics}}
+	{{.VarName}} {{.VarName}}Metric
+{{- end}}
+)
+
+// RegisterDefinitions registers every definition with r and points the
+// typed handles at it. It panics if a name is already taken by a metric
+// with another type or other labels.
+func RegisterDefinitions(r *prometheus.Registry) {
+{{- range $i, $m := .Metrics}}
+	{{$m.VarName}} = {{$m.VarName}}Metric{r.MustRegisterDefinition(Definitions[{{$i}}])}
+{{- end}}
+}
+{{range .Metrics}}
+// {{.VarName}}Metric is the typed handle of {{printf "%q" .Name}}: {{.Help}}
+type {{.VarName}}Metric struct{ h *prometheus.Handle }
+{{$params := join .Params ", "}}
+{{- if eq .Type "counter"}}
+func (m {{.VarName}}Metric) Inc({{if .Params}}{{$params}} string{{end}}) error {
+	return m.h.Add(1{{range .Params}}, {{.}}{{end}})
+}
+
+func (m {{.VarName}}Metric) Add({{if .Params}}{{$params}} string, {{end}}v float64) error {
+	return m.h.Add(v{{range .Params}}, {{.}}{{end}})
+}
+{{- else if eq .Type "gauge"}}
+func (m {{.VarName}}Metric) Set({{if .Params}}{{$params}} string, {{end}}v float64) error {
+	return m.h.Set(v{{range .Params}}, {{.}}{{end}})
+}
+
+func (m {{.VarName}}Metric) Add({{if .Params}}{{$params}} string, {{end}}v float64) error {
+	return m.h.Add(v{{range .Params}}, {{.}}{{end}})
+}
+{{- else}}
+func (m {{.VarName}}Metric) Observe(ctx context.Context, {{if .Params}}{{$params}} string, {{end}}v float64) error {
+	return m.h.Observe(ctx, v{{range .Params}}, {{.}}{{end}})
+}
+{{- if eq .Unit "ms"}}
+
+func (m {{.VarName}}Metric) ObserveDuration(ctx context.Context, {{if .Params}}{{$params}} string, {{end}}d time.Duration) error {
+	return m.h.Observe(ctx, float64(d)/float64(time.Millisecond){{range .Params}}, {{.}}{{end}})
+}
+{{- else if eq .Unit "s"}}
+
+func (m {{.VarName}}Metric) ObserveDuration(ctx context.Context, {{if .Params}}{{$params}} string, {{end}}d time.Duration) error {
+	return m.h.Observe(ctx, d.Seconds(){{range .Params}}, {{.}}{{end}})
+}
+{{- end}}
+{{- end}}
+{{end}}`))
@@ -1,12 +1,14 @@
 package prometheus
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
-	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 
-	"prometheus-test/lib/logger"
 	"prometheus-test/lib/util"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,191 +21,539 @@ const (
 	TypeQPS Type = iota
 	TypeTotal
 	TypeSummary
+	TypeGauge
 )
 
 var DefaultBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 5000, 10000, 50000, 100000, 500000}
 
-type prometheusInner struct {
-	serverName   string
-	idc          string
-	ip           string
-	qpsVec       map[string]*prometheus.CounterVec
-	totalVec     map[string]*prometheus.HistogramVec
-	summaryVec   map[string]*prometheus.SummaryVec
-	qpsMutex     sync.Mutex
-	totalMutex   sync.Mutex
-	summaryMutex sync.Mutex
+var errNotFound = errors.New("not correct name,please check")
+
+// errNegativeCount is returned for a negative counter delta, which
+// client_golang would panic on.
+var errNegativeCount = errors.New("counter delta must not be negative")
+
+// Registry is a set of metrics backed by its own prometheus registry, so
+// several instances can be created, gathered and thrown away independently.
+//
+// Updates never lock: registered metrics live in a copy-on-write map of
+// immutable handles, and ip and idc are const labels rather than entries
+// written into the caller's label map.
+type Registry struct {
+	registry    *prometheus.Registry
+	conf        Conf
+	namespace   string
+	subsystem   string
+	constLabels prometheus.Labels
+	metrics     atomic.Pointer[map[string]*metric]
+	// prom is false when the config leaves Prometheus out of the sinks,
+	// the metrics are then registered but never updated.
+	prom          bool
+	sinks         atomic.Pointer[[]Sink]
+	gaugeFunc     map[string]prometheus.GaugeFunc
+	registerMutex sync.Mutex
+	expiryOnce    sync.Once
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+// metric is the handle of a registered name. A handle is never modified
+// once published, registering another type under the same name (as the
+// compat kind does) publishes a new copy.
+type metric struct {
+	pType   Type
+	labels  []string
+	qps     *prometheus.CounterVec
+	total   *prometheus.HistogramVec
+	summary *prometheus.SummaryVec
+	gauge   *prometheus.GaugeVec
+	guard   *cardinalityGuard
+	// ttl is nil unless the metric's series expire.
+	ttl *seriesTracker
 }
 
-var inner *prometheusInner
+var inner *Registry
 
-func newPrometheusInner(name string, idcName string) *prometheusInner {
+// NewRegistry creates an empty Registry, or fails if conf is invalid.
+// Runtime and process collectors are left to the caller, see
+// gomonitor.Register.
+func NewRegistry(name string, idcName string, conf Conf) (*Registry, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
 	ipStr, err := util.GetInternalIP()
 	if err != nil {
 		ipStr = "0.0.0.0"
 	}
-	ins := &prometheusInner{
-		serverName: name,
-		idc:        idcName,
-		ip:         ipStr,
-		qpsVec:     make(map[string]*prometheus.CounterVec),
-		totalVec:   make(map[string]*prometheus.HistogramVec),
-		summaryVec: make(map[string]*prometheus.SummaryVec),
+	ins := &Registry{
+		registry:    prometheus.NewRegistry(),
+		conf:        conf,
+		namespace:   conf.namespace(),
+		subsystem:   conf.subsystem(name),
+		constLabels: prometheus.Labels{},
+		gaugeFunc:   make(map[string]prometheus.GaugeFunc),
+		closed:      make(chan struct{}),
+		prom:        conf.SinkEnabled(SinkPrometheus),
+	}
+	for k, v := range conf.Labels {
+		ins.constLabels[k] = v
 	}
-	return ins
+	identity := prometheus.Labels{"idc": idcName, "ip": ipStr}
+	if conf.TargetInfo {
+		ins.registeTargetInfo(name, identity)
+	} else {
+		for k, v := range identity {
+			ins.constLabels[k] = v
+		}
+	}
+	ins.metrics.Store(&map[string]*metric{})
+	ins.sinks.Store(&[]Sink{})
+	ins.registeQps(overflowMetricName, "updates of label sets refused by the series limit and folded into "+OverflowValue, []string{"metric"})
+	ins.registeQps(expiredMetricName, "series deleted after their ttl without updates", []string{"metric"})
+	return ins, nil
 }
 
-func (pI *prometheusInner) registeQps(name string, labels []string) {
-	pI.qpsMutex.Lock()
-	defer pI.qpsMutex.Unlock()
-	if _, ok := pI.qpsVec[name]; !ok {
-		pI.qpsVec[name] = prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: "dispatcher",
-			Subsystem: pI.serverName,
-			Name:      name,
-			Help:      "dispatcher qps",
-		}, append(labels, "idc", "ip"))
-		prometheus.MustRegister(pI.qpsVec[name])
+// registeTargetInfo exports the instance identity once, as the value-1
+// gauge target_info, instead of as labels on every series. Join it in
+// queries with "* on(instance) group_left(ip, idc) target_info".
+func (pI *Registry) registeTargetInfo(serverName string, identity prometheus.Labels) {
+	labels := prometheus.Labels{"server": serverName}
+	for k, v := range identity {
+		labels[k] = v
+	}
+	for k, v := range pI.constLabels {
+		labels[k] = v
 	}
+	info := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "target_info",
+		Help:        "Identity of this instance.",
+		ConstLabels: labels,
+	})
+	info.Set(1)
+	pI.registry.MustRegister(info)
+}
 
+// help returns the configured help text of name, or def.
+func (pI *Registry) help(name string, def string) string {
+	if mc, ok := pI.conf.Metric[name]; ok && mc.Help != "" {
+		return mc.Help
+	}
+	return def
 }
 
-func (pI *prometheusInner) registeTotal(name string, labels []string, bulks []float64) {
-	pI.totalMutex.Lock()
-	defer pI.totalMutex.Unlock()
-	if _, ok := pI.totalVec[name]; !ok {
+func (pI *Registry) lookup(name string) (*metric, bool) {
+	m, ok := (*pI.metrics.Load())[name]
+	return m, ok
+}
 
-		opts := prometheus.HistogramOpts{
-			Namespace: "dispatcher",
-			Subsystem: pI.serverName,
-			Name:      name,
-			Help:      "dispatcher total",
-		}
-		if bulks != nil {
-			opts.Buckets = bulks
-		} else {
-			opts.Buckets = DefaultBuckets
+// register publishes a copy of the handle of name after set has filled in
+// the missing vector. set must leave vectors that are already there alone.
+// Registering a name again with another type or other labels is a
+// programming error and panics, so it surfaces at startup.
+func (pI *Registry) register(name string, pType Type, labels []string, set func(m *metric)) {
+	pI.registerMutex.Lock()
+	defer pI.registerMutex.Unlock()
+	old := *pI.metrics.Load()
+	m := &metric{
+		pType:  pType,
+		labels: append([]string(nil), labels...),
+		guard:  newCardinalityGuard(pI.maxSeries(name)),
+		ttl:    newSeriesTracker(pI.conf.Metric[name].TTL),
+	}
+	if cur, ok := old[name]; ok {
+		if !sameClass(cur.pType, pType) || !sameLabels(cur.labels, labels) {
+			panic(fmt.Sprintf("prometheus: %q registered as type %d with labels %v, was type %d with labels %v",
+				name, pType, labels, cur.pType, cur.labels))
 		}
-		pI.totalVec[name] = prometheus.NewHistogramVec(opts, append(labels, "idc", "ip"))
-		prometheus.MustRegister(pI.totalVec[name])
+		cp := *cur
+		m = &cp
+	}
+	set(m)
+	if m.ttl != nil {
+		pI.startExpiry()
 	}
+	next := make(map[string]*metric, len(old)+1)
+	for k, v := range old {
+		next[k] = v
+	}
+	next[name] = m
+	pI.metrics.Store(&next)
+}
 
+// sameClass treats histograms and summaries alike, since the config may
+// register both under one name.
+func sameClass(a Type, b Type) bool {
+	if a == TypeSummary {
+		a = TypeTotal
+	}
+	if b == TypeSummary {
+		b = TypeTotal
+	}
+	return a == b
 }
 
-func (pI *prometheusInner) registerSummary(name string, labels []string, bulks []float64) {
-	pI.totalMutex.Lock()
-	defer pI.totalMutex.Unlock()
-	if _, ok := pI.summaryVec[name]; !ok {
+func sameLabels(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
 
-		opts := prometheus.SummaryOpts{
-			Namespace:  "dispatcher",
-			Subsystem:  pI.serverName,
-			Name:       name,
-			Objectives: map[float64]float64{0.5: 0.5, 0.9: 0.1, 0.99: 0.01},
+func (pI *Registry) registeQps(name string, help string, labels []string) {
+	pI.register(name, TypeQPS, labels, func(m *metric) {
+		if m.qps != nil {
+			return
 		}
+		m.qps = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   pI.namespace,
+			Subsystem:   pI.subsystem,
+			Name:        name,
+			Help:        pI.help(name, help),
+			ConstLabels: pI.constLabels,
+		}, labels)
+		pI.registry.MustRegister(m.qps)
+	})
+}
+
+func (pI *Registry) registeGauge(name string, help string, labels []string) {
+	pI.register(name, TypeGauge, labels, func(m *metric) {
+		if m.gauge != nil {
+			return
+		}
+		m.gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   pI.namespace,
+			Subsystem:   pI.subsystem,
+			Name:        name,
+			Help:        pI.help(name, help),
+			ConstLabels: pI.constLabels,
+		}, labels)
+		pI.registry.MustRegister(m.gauge)
+	})
+}
 
-		pI.summaryVec[name] = prometheus.NewSummaryVec(opts, append(labels, "idc", "ip"))
-		prometheus.MustRegister(pI.summaryVec[name])
+// RegisteGaugeFunc registers a gauge whose value is read from fn at scrape
+// time. fn must be safe for concurrent use.
+func (pI *Registry) RegisteGaugeFunc(name string, help string, fn func() float64) {
+	pI.registerMutex.Lock()
+	defer pI.registerMutex.Unlock()
+	if _, ok := pI.gaugeFunc[name]; !ok {
+		pI.gaugeFunc[name] = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   pI.namespace,
+			Subsystem:   pI.subsystem,
+			Name:        name,
+			Help:        pI.help(name, help),
+			ConstLabels: pI.constLabels,
+		}, fn)
+		pI.registry.MustRegister(pI.gaugeFunc[name])
 	}
+}
 
+// registeObserver registers a latency metric as a histogram, a summary or
+// both, depending on its MetricConf.
+func (pI *Registry) registeObserver(pType Type, name string, help string, labels []string, bulks []float64) {
+	mc := pI.conf.Metric[name]
+	switch mc.kind(pType) {
+	case KindHistogram:
+		pI.registeTotal(name, help, labels, bulks, mc)
+	case KindSummary:
+		pI.registerSummary(name, name, help, labels, mc)
+	case KindCompat:
+		pI.registeTotal(name, help, labels, bulks, mc)
+		pI.registerSummary(name, name+"_summary", help, labels, mc)
+	}
 }
 
-func (pI *prometheusInner) incQps(key string, kv map[string]string) (err error) {
-	pI.qpsMutex.Lock()
-	defer func() {
-		if r := recover(); r != nil {
-			_ = errors.New("check labels")
-			logger.NotCtxErrorf("stack:%v", string(debug.Stack()))
+func (pI *Registry) registeTotal(name string, help string, labels []string, bulks []float64, mc MetricConf) {
+	pI.register(name, TypeTotal, labels, func(m *metric) {
+		if m.total != nil {
+			return
 		}
-	}()
-	defer pI.qpsMutex.Unlock()
-	if qpsP, ok := pI.qpsVec[key]; ok {
-		kv["ip"] = pI.ip
-		kv["idc"] = pI.idc
-		qpsP.With(kv).Inc()
-	} else {
-		err = errors.New("not corret name,please check")
-	}
-	return
+		opts := prometheus.HistogramOpts{
+			Namespace:   pI.namespace,
+			Subsystem:   pI.subsystem,
+			Name:        name,
+			Help:        pI.help(name, help),
+			ConstLabels: pI.constLabels,
+		}
+		mc.applyHistogram(&opts, bulks)
+		m.total = prometheus.NewHistogramVec(opts, labels)
+		pI.registry.MustRegister(m.total)
+	})
 }
 
-func (pI *prometheusInner) updateTotal(key string, kv map[string]string, value float64) (err error) {
-	pI.totalMutex.Lock()
-	defer func() {
-		if r := recover(); r != nil {
-			_ = errors.New("check labels")
-			logger.NotCtxErrorf("stack:%v", string(debug.Stack()))
+func (pI *Registry) registerSummary(name string, metricName string, help string, labels []string, mc MetricConf) {
+	pI.register(name, TypeSummary, labels, func(m *metric) {
+		if m.summary != nil {
+			return
+		}
+		opts := prometheus.SummaryOpts{
+			Namespace:   pI.namespace,
+			Subsystem:   pI.subsystem,
+			Name:        metricName,
+			Help:        pI.help(name, help),
+			ConstLabels: pI.constLabels,
 		}
-	}()
-	defer pI.totalMutex.Unlock()
-	if totalP, ok := pI.totalVec[key]; ok {
-		kv["ip"] = pI.ip
-		kv["idc"] = pI.idc
-		totalP.With(kv).Observe(value)
+		mc.applySummary(&opts)
+		m.summary = prometheus.NewSummaryVec(opts, labels)
+		pI.registry.MustRegister(m.summary)
+	})
+}
+
+func (m *metric) addQps(kv map[string]string, value float64) error {
+	if m.qps == nil {
+		return errNotFound
+	}
+	if value < 0 {
+		return errNegativeCount
+	}
+	counter, err := m.qps.GetMetricWith(kv)
+	if err != nil {
+		return err
+	}
+	counter.Add(value)
+	return nil
+}
+
+func (m *metric) updateGauge(kv map[string]string, value float64, add bool) error {
+	if m.gauge == nil {
+		return errNotFound
+	}
+	gauge, err := m.gauge.GetMetricWith(kv)
+	if err != nil {
+		return err
+	}
+	if add {
+		gauge.Add(value)
 	} else {
-		err = errors.New("not corret name,please check")
+		gauge.Set(value)
 	}
-	return
+	return nil
 }
 
-func (pI *prometheusInner) updateSummary(key string, kv map[string]string, value float64) (err error) {
-	pI.summaryMutex.Lock()
-	defer func() {
-		if r := recover(); r != nil {
-			_ = errors.New("check labels")
-			logger.NotCtxErrorf("stack:%v", string(debug.Stack()))
+// observe feeds a latency metric whatever it was configured as. TypeTotal
+// and TypeSummary are interchangeable here since the config decides.
+func (m *metric) observe(kv map[string]string, value float64, exemplar prometheus.Labels) error {
+	if m.total == nil && m.summary == nil {
+		return errNotFound
+	}
+	if m.total != nil {
+		observer, err := m.total.GetMetricWith(kv)
+		if err != nil {
+			return err
 		}
-	}()
-	defer pI.summaryMutex.Unlock()
-	if summaryIP, ok := pI.summaryVec[key]; ok {
-		kv["ip"] = pI.ip
-		kv["idc"] = pI.idc
-		summaryIP.With(kv).Observe(value)
-	} else {
-		err = errors.New("not corret name,please check")
+		observeWithExemplar(observer, value, exemplar)
+	}
+	if m.summary != nil {
+		observer, err := m.summary.GetMetricWith(kv)
+		if err != nil {
+			return err
+		}
+		observer.Observe(value)
 	}
-	return
+	return nil
 }
 
-func Init(serverName string, idc string) {
-	inner = newPrometheusInner(serverName, idc)
+// observeWithExemplar observes value with exemplar when there is one and
+// the observer takes it, and without otherwise.
+func observeWithExemplar(observer prometheus.Observer, value float64, exemplar prometheus.Labels) {
+	if eo, ok := observer.(prometheus.ExemplarObserver); ok && exemplar != nil {
+		eo.ObserveWithExemplar(value, exemplar)
+		return
+	}
+	observer.Observe(value)
 }
 
-func Registe(pType Type, name string, labels []string, bulks []float64) {
+func (pI *Registry) Registe(pType Type, name string, labels []string, bulks []float64) {
 	switch pType {
 	case TypeQPS:
-		inner.registeQps(name, labels)
-	case TypeTotal:
-		inner.registeTotal(name, labels, bulks)
-	case TypeSummary:
-		inner.registerSummary(name, labels, bulks)
+		pI.registeQps(name, pI.namespace+" qps", labels)
+	case TypeTotal, TypeSummary:
+		pI.registeObserver(pType, name, pI.namespace+" total", labels, bulks)
+	case TypeGauge:
+		pI.registeGauge(name, pI.namespace+" gauge", labels)
 	}
 }
 
-func Update(pType Type, name string, kv map[string]string, value float64) error {
+// Update adds value to a counter, observes it on a histogram or summary,
+// and sets it on a gauge. kv is only read, callers may reuse it. A
+// negative value for a counter is refused.
+func (pI *Registry) Update(pType Type, name string, kv map[string]string, value float64) error {
+	m, ok := pI.lookup(name)
+	if !ok {
+		return errNotFound
+	}
+	if pType == TypeQPS && value < 0 {
+		return errNegativeCount
+	}
+	kv, err := pI.guard(name, m, kv)
+	if err != nil {
+		return err
+	}
+	pI.emit(pType, name, kv, value, false)
+	if !pI.prom {
+		return nil
+	}
 	switch pType {
 	case TypeQPS:
-		return inner.incQps(name, kv)
-	case TypeTotal:
-		return inner.updateTotal(name, kv, value)
-	case TypeSummary:
-		return inner.updateSummary(name, kv, value)
+		return m.addQps(kv, value)
+	case TypeTotal, TypeSummary:
+		return m.observe(kv, value, nil)
+	case TypeGauge:
+		return m.updateGauge(kv, value, false)
 	}
 	return nil
 }
 
-func Inc(pType Type, name string, kv map[string]string) error {
+// Add adds delta to a counter or gauge. A negative delta for a counter
+// is refused.
+func (pI *Registry) Add(pType Type, name string, kv map[string]string, delta float64) error {
+	m, ok := pI.lookup(name)
+	if !ok {
+		return errNotFound
+	}
+	if pType != TypeQPS && pType != TypeGauge {
+		return errors.New("add is only supported for counters and gauges")
+	}
+	if pType == TypeQPS && delta < 0 {
+		return errNegativeCount
+	}
+	kv, err := pI.guard(name, m, kv)
+	if err != nil {
+		return err
+	}
+	pI.emit(pType, name, kv, delta, true)
+	if !pI.prom {
+		return nil
+	}
 	switch pType {
 	case TypeQPS:
-		return inner.incQps(name, kv)
-	case TypeTotal:
-		return inner.updateTotal(name, kv, 1)
-	case TypeSummary:
-		return inner.updateSummary(name, kv, 1)
+		return m.addQps(kv, delta)
+	case TypeGauge:
+		return m.updateGauge(kv, delta, true)
+	}
+	return nil
+}
+
+// UpdateCtx is Update plus an exemplar taken from ctx. Only histograms
+// can carry exemplars, a metric configured as a summary drops it.
+func (pI *Registry) UpdateCtx(ctx context.Context, pType Type, name string, kv map[string]string, value float64) error {
+	if pType != TypeTotal && pType != TypeSummary {
+		return pI.Update(pType, name, kv, value)
+	}
+	m, ok := pI.lookup(name)
+	if !ok {
+		return errNotFound
+	}
+	kv, err := pI.guard(name, m, kv)
+	if err != nil {
+		return err
+	}
+	pI.emit(pType, name, kv, value, false)
+	if !pI.prom {
+		return nil
+	}
+	return m.observe(kv, value, exemplarLabels(ctx))
+}
+
+func (pI *Registry) Inc(pType Type, name string, kv map[string]string) error {
+	if pType == TypeGauge {
+		return pI.Add(pType, name, kv, 1)
+	}
+	return pI.Update(pType, name, kv, 1)
+}
+
+// Close stops the background expiry of stale series. It matches
+// recycle.ResourceRecyclable.
+func (pI *Registry) Close() bool {
+	pI.closeOnce.Do(func() {
+		close(pI.closed)
+	})
+	return true
+}
+
+// Registerer exposes the underlying registry for collectors that are not
+// managed through Registe. Their series get the same const labels as the
+// metrics of the Registry.
+func (pI *Registry) Registerer() prometheus.Registerer {
+	return prometheus.WrapRegistererWith(pI.constLabels, pI.registry)
+}
+
+func (pI *Registry) Gatherer() prometheus.Gatherer {
+	return pI.registry
+}
+
+// NewHttpHander serves this registry. It negotiates the OpenMetrics
+// format, which is required for exemplars to be exposed, only when
+// Conf.OpenMetrics is set.
+func (pI *Registry) NewHttpHander() http.Handler {
+	return promhttp.InstrumentMetricHandler(
+		pI.registry,
+		promhttp.HandlerFor(pI.registry, promhttp.HandlerOpts{
+			EnableOpenMetrics: pI.conf.OpenMetrics,
+		}),
+	)
+}
+
+// maxExemplarValue bounds the runes of an exemplar label value. The ids
+// come from clients and client_golang panics past 128 runes in total.
+const maxExemplarValue = 64
+
+// exemplarLabels links an observation to the trace id of the request, or
+// to its request id when the request is not traced. An id that is not
+// valid UTF-8 or too long gives no exemplar.
+func exemplarLabels(ctx context.Context) prometheus.Labels {
+	if traceId := util.GetTraceId(ctx); traceId != "" && exemplarValue(traceId) {
+		return prometheus.Labels{"trace_id": traceId}
+	}
+	if requestId := util.GetRequestId(ctx); requestId != "null_request_id" && exemplarValue(requestId) {
+		return prometheus.Labels{"request_id": requestId}
 	}
 	return nil
 }
 
-func NewHttpHander() http.Handler {
-	return promhttp.Handler()
+func exemplarValue(v string) bool {
+	return utf8.ValidString(v) && utf8.RuneCountInString(v) <= maxExemplarValue
+}
+
+// Init creates the default Registry used by the package-level functions.
+func Init(serverName string, idc string, conf Conf) error {
+	reg, err := NewRegistry(serverName, idc, conf)
+	if err != nil {
+		return err
+	}
+	inner = reg
+	return nil
+}
+
+// Default returns the Registry created by Init.
+func Default() *Registry {
+	return inner
+}
+
+func Registe(pType Type, name string, labels []string, bulks []float64) {
+	inner.Registe(pType, name, labels, bulks)
+}
+
+func Update(pType Type, name string, kv map[string]string, value float64) error {
+	return inner.Update(pType, name, kv, value)
+}
+
+func UpdateCtx(ctx context.Context, pType Type, name string, kv map[string]string, value float64) error {
+	return inner.UpdateCtx(ctx, pType, name, kv, value)
+}
+
+func Inc(pType Type, name string, kv map[string]string) error {
+	return inner.Inc(pType, name, kv)
+}
+
+func Add(pType Type, name string, kv map[string]string, delta float64) error {
+	return inner.Add(pType, name, kv, delta)
+}
+
+func RegisteGaugeFunc(name string, help string, fn func() float64) {
+	inner.RegisteGaugeFunc(name, help, fn)
 }
@@ -0,0 +1,312 @@
+package prometheus
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"prometheus-test/lib/logger"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMain(m *testing.M) {
+	// Failed pushes and sends are logged.
+	if err := logger.Init(logger.LoggerConf{Level: "fatal", Business: "stdout", Access: "stdout"}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func newBenchRegistry(b *testing.B, maxSeries int) *Registry {
+	b.Helper()
+	reg, err := NewRegistry("bench", "test", Conf{MaxSeries: maxSeries})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { reg.Close() })
+	reg.Registe(TypeQPS, "requests", []string{"method", "status"}, nil)
+	reg.Registe(TypeTotal, "latency", []string{"method", "status"}, nil)
+	return reg
+}
+
+// BenchmarkUpdate updates known label sets of an unlimited metric, one
+// counter and one histogram update per op.
+func BenchmarkUpdate(b *testing.B) {
+	reg := newBenchRegistry(b, -1)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		kv := map[string]string{"method": "/get", "status": "200"}
+		for pb.Next() {
+			if err := reg.Update(TypeQPS, "requests", kv, 1); err != nil {
+				b.Error(err)
+			}
+			if err := reg.Update(TypeTotal, "latency", kv, 12); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+// mutexBaseline is the update path before updates were made lock-free:
+// a mutex per metric type around a map lookup, with ip and idc written
+// into the caller's label map. BenchmarkUpdateMutexBaseline runs it for
+// comparison with BenchmarkUpdate, e.g. with -cpu 1,4,8.
+type mutexBaseline struct {
+	qpsMutex   sync.Mutex
+	totalMutex sync.Mutex
+	qpsVec     map[string]*prometheus.CounterVec
+	totalVec   map[string]*prometheus.HistogramVec
+}
+
+func (p *mutexBaseline) addQps(name string, kv map[string]string, value float64) {
+	p.qpsMutex.Lock()
+	defer p.qpsMutex.Unlock()
+	if v, ok := p.qpsVec[name]; ok {
+		kv["ip"] = "127.0.0.1"
+		kv["idc"] = "test"
+		v.With(kv).Add(value)
+	}
+}
+
+func (p *mutexBaseline) observe(name string, kv map[string]string, value float64) {
+	p.totalMutex.Lock()
+	defer p.totalMutex.Unlock()
+	if v, ok := p.totalVec[name]; ok {
+		kv["ip"] = "127.0.0.1"
+		kv["idc"] = "test"
+		v.With(kv).Observe(value)
+	}
+}
+
+func BenchmarkUpdateMutexBaseline(b *testing.B) {
+	labels := []string{"method", "status", "ip", "idc"}
+	p := &mutexBaseline{
+		qpsVec: map[string]*prometheus.CounterVec{
+			"requests": prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, labels),
+		},
+		totalVec: map[string]*prometheus.HistogramVec{
+			"latency": prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, labels),
+		},
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		kv := map[string]string{"method": "/get", "status": "200"}
+		for pb.Next() {
+			p.addQps("requests", kv, 1)
+			p.observe("latency", kv, 12)
+		}
+	})
+}
+
+// BenchmarkUpdateGuarded cycles through more label sets than the series
+// limit, so updates are admitted or folded into the overflow series.
+func BenchmarkUpdateGuarded(b *testing.B) {
+	reg := newBenchRegistry(b, 16)
+	statuses := make([]string, 64)
+	for i := range statuses {
+		statuses[i] = strconv.Itoa(200 + i)
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		kv := map[string]string{"method": "/get"}
+		i := 0
+		for pb.Next() {
+			kv["status"] = statuses[i%len(statuses)]
+			i++
+			if err := reg.Update(TypeQPS, "requests", kv, 1); err != nil {
+				b.Error(err)
+			}
+			if err := reg.Update(TypeTotal, "latency", kv, 12); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+// BenchmarkUpdateHandle is BenchmarkUpdate through handles, as the
+// generated typed metrics update.
+func BenchmarkUpdateHandle(b *testing.B) {
+	reg, err := NewRegistry("bench", "test", Conf{MaxSeries: -1})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { reg.Close() })
+	requests := reg.MustRegisterDefinition(Definition{Name: "requests", Type: TypeQPS, Labels: []string{"method", "status"}})
+	latency := reg.MustRegisterDefinition(Definition{Name: "latency", Type: TypeTotal, Labels: []string{"method", "status"}})
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := requests.Add(1, "/get", "200"); err != nil {
+				b.Error(err)
+			}
+			if err := latency.Observe(ctx, 12, "/get", "200"); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func TestCounterRejectsNegative(t *testing.T) {
+	reg, err := NewRegistry("test", "test", Conf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Close()
+	reg.Registe(TypeQPS, "requests", []string{"method"}, nil)
+	h := reg.MustRegisterDefinition(Definition{Name: "jobs", Type: TypeQPS, Labels: []string{"status"}})
+	kv := map[string]string{"method": "/get"}
+	if err := reg.Update(TypeQPS, "requests", kv, 2); err != nil {
+		t.Fatal(err)
+	}
+	for name, update := range map[string]func() error{
+		"Update":     func() error { return reg.Update(TypeQPS, "requests", kv, -1) },
+		"Add":        func() error { return reg.Add(TypeQPS, "requests", kv, -1) },
+		"Handle.Add": func() error { return h.Add(-1, "ok") },
+	} {
+		if err := update(); err != errNegativeCount {
+			t.Errorf("%s of -1: err=%v, want %v", name, err, errNegativeCount)
+		}
+	}
+	m, _ := reg.lookup("requests")
+	if v := testutil.ToFloat64(m.qps.WithLabelValues("/get")); v != 2 {
+		t.Errorf("counter = %v, want 2", v)
+	}
+}
+
+func TestGuardRefusesBadLabelsWithoutSlot(t *testing.T) {
+	reg, err := NewRegistry("test", "test", Conf{MaxSeries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Close()
+	reg.Registe(TypeQPS, "requests", []string{"method"}, nil)
+	h := reg.MustRegisterDefinition(Definition{Name: "jobs", Type: TypeQPS, Labels: []string{"status"}})
+	for name, update := range map[string]func() error{
+		"missing label": func() error { return reg.Update(TypeQPS, "requests", map[string]string{"path": "/get"}, 1) },
+		"extra label": func() error {
+			return reg.Update(TypeQPS, "requests", map[string]string{"method": "/get", "path": "/"}, 1)
+		},
+		"invalid UTF-8": func() error { return reg.Update(TypeQPS, "requests", map[string]string{"method": "\xff"}, 1) },
+		"short values":  func() error { return h.Add(1) },
+		"bad value":     func() error { return h.Add(1, "\xff") },
+	} {
+		if err := update(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	for _, name := range []string{"requests", "jobs"} {
+		m, _ := reg.lookup(name)
+		if n := m.guard.count.Load(); n != 0 {
+			t.Errorf("%s: %d slots taken by refused label sets", name, n)
+		}
+	}
+	if err := reg.Update(TypeQPS, "requests", map[string]string{"method": "/get"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := reg.lookup("requests")
+	if v := testutil.ToFloat64(m.qps.WithLabelValues("/get")); v != 1 {
+		t.Errorf("first valid label set folded: counter = %v, want 1", v)
+	}
+}
+
+func TestExemplarLabels(t *testing.T) {
+	traceId := "4bf92f3577b34da6a3ce929d0e0e4736"
+	long := strings.Repeat("é", maxExemplarValue)
+	for _, tt := range []struct {
+		name      string
+		traceId   string
+		requestId string
+		want      prometheus.Labels
+	}{
+		{"trace id first", traceId, "req-1", prometheus.Labels{"trace_id": traceId}},
+		{"request id without trace", "", "req-1", prometheus.Labels{"request_id": "req-1"}},
+		{"neither", "", "", nil},
+		{"64 runes", "", long, prometheus.Labels{"request_id": long}},
+		{"65 runes", "", long + "a", nil},
+		{"invalid UTF-8 request id", "", "req-\xff", nil},
+		{"invalid UTF-8 trace id falls back", "\xff", "req-1", prometheus.Labels{"request_id": "req-1"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.traceId != "" {
+				ctx = context.WithValue(ctx, "trace_id", tt.traceId)
+			}
+			if tt.requestId != "" {
+				ctx = context.WithValue(ctx, "request_id", tt.requestId)
+			}
+			if got := exemplarLabels(ctx); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("exemplarLabels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateCtxAttachesExemplar(t *testing.T) {
+	reg, err := NewRegistry("test", "test", Conf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Close()
+	reg.Registe(TypeTotal, "latency", []string{"method"}, []float64{10, 100})
+	ctx := context.WithValue(context.Background(), "request_id", "req-1")
+	if err := reg.UpdateCtx(ctx, TypeTotal, "latency", map[string]string{"method": "/get"}, 12); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gatherer().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			for _, b := range m.GetHistogram().GetBucket() {
+				if e := b.GetExemplar(); e != nil {
+					if l := e.GetLabel(); len(l) != 1 || l[0].GetName() != "request_id" || l[0].GetValue() != "req-1" {
+						t.Errorf("exemplar labels = %v, want request_id=req-1", l)
+					}
+					if b.GetUpperBound() != 100 {
+						t.Errorf("exemplar in bucket %v, want 100", b.GetUpperBound())
+					}
+					return
+				}
+			}
+		}
+	}
+	t.Error("no exemplar gathered")
+}
+
+func TestRegistriesAreIndependent(t *testing.T) {
+	regs := make([]*Registry, 2)
+	for i := range regs {
+		reg, err := NewRegistry("test", "test", Conf{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reg.Close()
+		// The same name in both registries must not clash.
+		reg.Registe(TypeQPS, "requests", []string{"method"}, nil)
+		if err := reg.Add(TypeQPS, "requests", map[string]string{"method": "/get"}, float64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+		regs[i] = reg
+	}
+	for i, reg := range regs {
+		rec := httptest.NewRecorder()
+		reg.NewHttpHander().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		line := regexp.MustCompile(`(?m)^\S*requests\{[^}]*method="/get"[^}]*\} (\S+)$`).FindStringSubmatch(rec.Body.String())
+		if line == nil {
+			t.Fatalf("registry %d does not serve requests:\n%s", i, rec.Body.String())
+		}
+		if want := strconv.Itoa(i + 1); line[1] != want {
+			t.Errorf("registry %d serves requests = %s, want %s", i, line[1], want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"prometheus-test/lib/logger"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// PushConf is the [metrics.push] section. Batch jobs that exit before a
+// scrape push their registry to a Pushgateway instead.
+type PushConf struct {
+	Enable bool   `toml:"enable"`
+	URL    string `toml:"url"`
+	Job    string `toml:"job"`
+	// Grouping is the grouping key below the job. An "instance" entry
+	// defaults to the hostname so replicas don't overwrite each other.
+	Grouping     map[string]string `toml:"grouping"`
+	Interval     string            `toml:"interval"`
+	Timeout      string            `toml:"timeout"`
+	Retries      int               `toml:"retries"`
+	RetryBackoff string            `toml:"retry_backoff"`
+}
+
+// Pusher pushes a Registry periodically and once more on Stop.
+type Pusher struct {
+	pusher   *push.Pusher
+	interval time.Duration
+	timeout  time.Duration
+	retries  int
+	backoff  time.Duration
+	started  bool
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPusher builds a Pusher for the registry. Call Start to push
+// periodically and Stop to push a final time.
+func (pI *Registry) NewPusher(conf PushConf) (*Pusher, error) {
+	if conf.URL == "" || conf.Job == "" {
+		return nil, errors.New("push needs url and job")
+	}
+	interval, err := parseDuration(conf.Interval, 15*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseDuration(conf.Timeout, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	backoff, err := parseDuration(conf.RetryBackoff, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	retries := conf.Retries
+	if retries == 0 {
+		retries = 3
+	}
+
+	pusher := push.New(conf.URL, conf.Job).
+		Gatherer(pI.registry).
+		Client(&http.Client{Timeout: timeout})
+	if _, ok := conf.Grouping["instance"]; !ok {
+		if hostname, err := os.Hostname(); err == nil {
+			pusher.Grouping("instance", hostname)
+		}
+	}
+	for k, v := range conf.Grouping {
+		pusher.Grouping(k, v)
+	}
+	return &Pusher{
+		pusher:   pusher,
+		interval: interval,
+		timeout:  timeout,
+		retries:  retries,
+		backoff:  backoff,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Push replaces the pushed group with the current registry, retrying with
+// a doubling backoff. Stop cuts the retries short.
+func (p *Pusher) Push() error {
+	backoff := p.backoff
+	var err error
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-p.stop:
+				return err
+			}
+			backoff *= 2
+		}
+		if err = p.pusher.Push(); err == nil {
+			return nil
+		}
+		logger.NotCtxErrorf("prometheus push failed,attempt=%d,err=%v", attempt+1, err)
+	}
+	return err
+}
+
+func (p *Pusher) Start() {
+	p.started = true
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = p.Push()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the periodic pushes and pushes a last time, so the final
+// values of a short-lived run are kept. The last push is a single attempt
+// bounded by the timeout, so shutdown doesn't wait for retries. It
+// matches recycle.ResourceRecyclable.
+func (p *Pusher) Stop() bool {
+	stopped := false
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		if p.started {
+			<-p.done
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		defer cancel()
+		err := p.pusher.PushContext(ctx)
+		if err != nil {
+			logger.NotCtxErrorf("prometheus push failed,final,err=%v", err)
+		}
+		stopped = err == nil
+	})
+	return stopped
+}
@@ -0,0 +1,145 @@
+package prometheus
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+type pushRequest struct {
+	method   string
+	path     string
+	families []string
+}
+
+// pushgateway answers the first failures pushes with 503.
+func pushgateway(t *testing.T, failures int) (*httptest.Server, func() []pushRequest) {
+	var mutex sync.Mutex
+	var requests []pushRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := pushRequest{method: r.Method, path: r.URL.Path}
+		dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			var mf dto.MetricFamily
+			if err := dec.Decode(&mf); err != nil {
+				if err != io.EOF {
+					t.Errorf("decode pushed body: %v", err)
+				}
+				break
+			}
+			req.families = append(req.families, mf.GetName())
+		}
+		mutex.Lock()
+		requests = append(requests, req)
+		n := len(requests)
+		mutex.Unlock()
+		if n <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []pushRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]pushRequest(nil), requests...)
+	}
+}
+
+func newPushRegistry(t *testing.T) *Registry {
+	reg, err := NewRegistry("push", "test", Conf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reg.Close() })
+	reg.Registe(TypeQPS, "jobs", []string{"status"}, nil)
+	if err := reg.Inc(TypeQPS, "jobs", map[string]string{"status": "ok"}); err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+func TestPushRetries(t *testing.T) {
+	server, requests := pushgateway(t, 2)
+	pusher, err := newPushRegistry(t).NewPusher(PushConf{
+		URL:          server.URL,
+		Job:          "batch",
+		Grouping:     map[string]string{"instance": "host-1"},
+		Retries:      2,
+		RetryBackoff: "1ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pusher.Push(); err != nil {
+		t.Fatalf("push after two 503s: %v", err)
+	}
+	got := requests()
+	if len(got) != 3 {
+		t.Fatalf("got %d requests, want 3", len(got))
+	}
+	for _, req := range got {
+		if req.method != http.MethodPut {
+			t.Errorf("method %s, want PUT", req.method)
+		}
+		if req.path != "/metrics/job/batch/instance/host-1" {
+			t.Errorf("path %s, want /metrics/job/batch/instance/host-1", req.path)
+		}
+		found := false
+		for _, name := range req.families {
+			found = found || name == "dispatcher_push_jobs"
+		}
+		if !found {
+			t.Errorf("pushed families %v, want dispatcher_push_jobs", req.families)
+		}
+	}
+}
+
+func TestPushGivesUp(t *testing.T) {
+	server, requests := pushgateway(t, 100)
+	pusher, err := newPushRegistry(t).NewPusher(PushConf{URL: server.URL, Job: "batch", Retries: 1, RetryBackoff: "1ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pusher.Push(); err == nil {
+		t.Fatal("push succeeded against a failing gateway")
+	}
+	if n := len(requests()); n != 2 {
+		t.Fatalf("got %d requests, want 2", n)
+	}
+}
+
+func TestPusherStopSkipsBackoff(t *testing.T) {
+	server, requests := pushgateway(t, 100)
+	pusher, err := newPushRegistry(t).NewPusher(PushConf{
+		URL:          server.URL,
+		Job:          "batch",
+		Interval:     "1ms",
+		Retries:      3,
+		RetryBackoff: "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pusher.Start()
+	for len(requests()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	stopped := make(chan bool)
+	go func() { stopped <- pusher.Stop() }()
+	select {
+	case ok := <-stopped:
+		if ok {
+			t.Error("Stop reported a successful push to a failing gateway")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop waited for the retry backoff")
+	}
+}
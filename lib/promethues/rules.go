@@ -0,0 +1,299 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// SLOConf sets the objectives of a histogram or summary metric, keyed by
+// its name, for the generated burn-rate alerts. The burn rates assume a
+// 30 day SLO period.
+type SLOConf struct {
+	// Objective is the share of requests that must not match Errors,
+	// e.g. 0.999.
+	Objective float64 `toml:"objective"`
+	// Errors selects failed requests, e.g. `status=~"5.."`.
+	Errors string `toml:"errors"`
+	// Latency is a bucket bound of the histogram. LatencyObjective is the
+	// share of requests that must be at most that slow.
+	Latency          float64 `toml:"latency"`
+	LatencyObjective float64 `toml:"latency_objective"`
+}
+
+// rateWindow is the range of the per-metric recording rules.
+const rateWindow = "5m"
+
+// burnWindows lists every window used by burnRates, shortest first.
+var burnWindows = []string{"5m", "30m", "1h", "2h", "6h", "1d", "3d"}
+
+// burnRates are the multi-window, multi-burn-rate alerts of the SRE
+// workbook: an alert fires when both windows burn faster than factor.
+var burnRates = []struct {
+	long, short string
+	factor      float64
+	severity    string
+}{
+	{"1h", "5m", 14.4, "page"},
+	{"6h", "30m", 6, "page"},
+	{"1d", "2h", 3, "ticket"},
+	{"3d", "6h", 1, "ticket"},
+}
+
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// Rules renders a Prometheus rule file for defs as registered by
+// serverName under c: rates and quantiles of every metric, and burn-rate
+// alerts for the metrics in c.SLO.
+func (c Conf) Rules(serverName string, defs []Definition) ([]byte, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if err := c.checkSLO(defs); err != nil {
+		return nil, err
+	}
+	subsystem := c.subsystem(serverName)
+	recording := ruleGroup{Name: subsystem + ".rules"}
+	for _, d := range defs {
+		recording.Rules = append(recording.Rules, c.recordingRules(serverName, d)...)
+	}
+	file := ruleFile{Groups: []ruleGroup{recording}}
+	slo := ruleGroup{Name: subsystem + ".slo"}
+	for _, d := range defs {
+		if s, ok := c.SLO[d.Name]; ok {
+			slo.Rules = append(slo.Rules, c.sloRules(serverName, d, s)...)
+		}
+	}
+	if len(slo.Rules) > 0 {
+		file.Groups = append(file.Groups, slo)
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c Conf) fullName(serverName string, name string) string {
+	return prometheus.BuildFQName(c.namespace(), c.subsystem(serverName), name)
+}
+
+// staticKeys are the labels every series shares within a deployment.
+// Aggregations keep them so clusters and envs are not mixed.
+func (c Conf) staticKeys() []string {
+	keys := make([]string, 0, len(c.Labels))
+	for k := range c.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func by(keys ...[]string) string {
+	var all []string
+	for _, k := range keys {
+		all = append(all, k...)
+	}
+	if len(all) == 0 {
+		return ""
+	}
+	return " by (" + strings.Join(all, ", ") + ")"
+}
+
+// quantiles are the recorded quantiles of a histogram, the objectives of
+// its summary if configured.
+func (mc MetricConf) quantiles() []float64 {
+	var qs []float64
+	for q := range mc.objectives() {
+		qs = append(qs, q)
+	}
+	sort.Float64s(qs)
+	return qs
+}
+
+// quantileName turns 0.5 into "p50" and 0.999 into "p999".
+func quantileName(q float64) string {
+	return "p" + strings.Replace(strconv.FormatFloat(q*100, 'f', -1, 64), ".", "", 1)
+}
+
+// recordName follows the level:metric:operations convention, the level
+// being the labels kept by the aggregation.
+func recordName(labels []string, full string, op string) string {
+	level := strings.Join(labels, "_")
+	if level == "" {
+		level = "all"
+	}
+	return level + ":" + full + ":" + op
+}
+
+func (c Conf) recordingRules(serverName string, d Definition) []rule {
+	full := c.fullName(serverName, d.Name)
+	keep := by(c.staticKeys(), d.Labels)
+	rate := "rate" + rateWindow
+	switch d.Type {
+	case TypeQPS:
+		return []rule{{
+			Record: recordName(d.Labels, full, rate),
+			Expr:   fmt.Sprintf("sum%s (rate(%s[%s]))", keep, full, rateWindow),
+		}}
+	case TypeTotal, TypeSummary:
+		mc := c.Metric[d.Name]
+		rules := []rule{{
+			Record: recordName(d.Labels, full, rate),
+			Expr:   fmt.Sprintf("sum%s (rate(%s_count[%s]))", keep, full, rateWindow),
+		}}
+		if mc.kind(d.Type) == KindSummary {
+			return rules
+		}
+		bucketKeep := by([]string{"le"}, c.staticKeys(), d.Labels)
+		for _, q := range mc.quantiles() {
+			rules = append(rules, rule{
+				Record: recordName(d.Labels, full, quantileName(q)+"_"+rate),
+				Expr: fmt.Sprintf("histogram_quantile(%s, sum%s (rate(%s_bucket[%s])))",
+					strconv.FormatFloat(q, 'f', -1, 64), bucketKeep, full, rateWindow),
+			})
+		}
+		return rules
+	}
+	return nil
+}
+
+func (c Conf) sloRules(serverName string, d Definition, s SLOConf) []rule {
+	full := c.fullName(serverName, d.Name)
+	keep := by(c.staticKeys())
+	alertName := exportedName(d.Name)
+	var rules []rule
+	if s.Objective > 0 {
+		selector := "{" + s.Errors + "}"
+		for _, w := range burnWindows {
+			rules = append(rules, rule{
+				Record: "slo:" + full + ":error_ratio_rate" + w,
+				Expr: fmt.Sprintf("sum%s (rate(%s_count%s[%s])) / sum%s (rate(%s_count[%s]))",
+					keep, full, selector, w, keep, full, w),
+			})
+		}
+		rules = append(rules, burnAlerts(alertName+"ErrorBudgetBurn", "slo:"+full+":error_ratio_rate", s.Objective,
+			fmt.Sprintf("%s is failing faster than its %s availability objective allows.", d.Name, percent(s.Objective)))...)
+	}
+	if s.LatencyObjective > 0 {
+		le := latencyMatcher(s.Latency)
+		for _, w := range burnWindows {
+			rules = append(rules, rule{
+				Record: "slo:" + full + ":latency_ratio_rate" + w,
+				Expr: fmt.Sprintf("1 - sum%s (rate(%s_bucket{le=~%q}[%s])) / sum%s (rate(%s_count[%s]))",
+					keep, full, le, w, keep, full, w),
+			})
+		}
+		rules = append(rules, burnAlerts(alertName+"LatencyBudgetBurn", "slo:"+full+":latency_ratio_rate", s.LatencyObjective,
+			fmt.Sprintf("%s is slower than %s too often for its %s latency objective.",
+				d.Name, strconv.FormatFloat(s.Latency, 'f', -1, 64), percent(s.LatencyObjective)))...)
+	}
+	return rules
+}
+
+func burnAlerts(name string, ratio string, objective float64, summary string) []rule {
+	budget := strconv.FormatFloat(1-objective, 'g', 6, 64)
+	var rules []rule
+	for _, severity := range []string{"page", "ticket"} {
+		var conds []string
+		for _, b := range burnRates {
+			if b.severity != severity {
+				continue
+			}
+			factor := strconv.FormatFloat(b.factor, 'f', -1, 64)
+			conds = append(conds, fmt.Sprintf("(%s%s > (%s * %s) and %s%s > (%s * %s))",
+				ratio, b.long, factor, budget, ratio, b.short, factor, budget))
+		}
+		rules = append(rules, rule{
+			Alert:       name,
+			Expr:        strings.Join(conds, "\nor\n"),
+			For:         "2m",
+			Labels:      map[string]string{"severity": severity},
+			Annotations: map[string]string{"summary": summary},
+		})
+	}
+	return rules
+}
+
+// latencyMatcher matches the le label of a bucket bound whether it was
+// exposed as "500" (text format) or "500.0" (OpenMetrics).
+func latencyMatcher(bound float64) string {
+	le := regexp.QuoteMeta(strconv.FormatFloat(bound, 'f', -1, 64))
+	if bound == float64(int64(bound)) {
+		le += `(\.0)?`
+	}
+	return le
+}
+
+func percent(f float64) string {
+	return strconv.FormatFloat(f*100, 'f', -1, 64) + "%"
+}
+
+// exportedName turns "dependence_qps" into "DependenceQps" for alert names.
+func exportedName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+func (c Conf) checkSLO(defs []Definition) error {
+	byName := make(map[string]Definition, len(defs))
+	for _, d := range defs {
+		byName[d.Name] = d
+	}
+	for name, s := range c.SLO {
+		d, ok := byName[name]
+		if !ok || (d.Type != TypeTotal && d.Type != TypeSummary) {
+			return fmt.Errorf("slo %q: no histogram or summary of that name", name)
+		}
+		if s.Objective < 0 || s.Objective >= 1 || s.LatencyObjective < 0 || s.LatencyObjective >= 1 {
+			return fmt.Errorf("slo %q: objectives must be below 1, e.g. 0.999", name)
+		}
+		if s.Objective > 0 && s.Errors == "" {
+			return fmt.Errorf("slo %q: objective needs errors", name)
+		}
+		if s.LatencyObjective == 0 {
+			continue
+		}
+		mc := c.Metric[name]
+		if mc.kind(d.Type) == KindSummary {
+			return fmt.Errorf("slo %q: latency objective needs a histogram", name)
+		}
+		opts := prometheus.HistogramOpts{}
+		mc.applyHistogram(&opts, d.Buckets)
+		found := false
+		for _, b := range opts.Buckets {
+			found = found || b == s.Latency
+		}
+		if !found {
+			return fmt.Errorf("slo %q: latency %v is not a bucket of %v", name, s.Latency, opts.Buckets)
+		}
+	}
+	return nil
+}
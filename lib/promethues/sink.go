@@ -0,0 +1,67 @@
+package prometheus
+
+const (
+	SinkPrometheus = "prometheus"
+	SinkStatsD     = "statsd"
+)
+
+// Sink receives the updates of a Registry next to, or instead of, its
+// Prometheus metrics. Labels have already been folded by the series limit
+// and must not be modified or kept.
+type Sink interface {
+	Count(name string, labels map[string]string, value float64)
+	Gauge(name string, labels map[string]string, value float64, add bool)
+	Observe(name string, labels map[string]string, value float64)
+}
+
+// SinkEnabled reports whether the sink is listed in Conf.Sinks. Without
+// sinks in the config only Prometheus is fed.
+func (c Conf) SinkEnabled(sink string) bool {
+	if len(c.Sinks) == 0 {
+		return sink == SinkPrometheus
+	}
+	for _, s := range c.Sinks {
+		if s == sink {
+			return true
+		}
+	}
+	return false
+}
+
+// AddSink fans every later update of the Registry out to s as well.
+func (pI *Registry) AddSink(s Sink) {
+	pI.registerMutex.Lock()
+	defer pI.registerMutex.Unlock()
+	old := *pI.sinks.Load()
+	next := append(append(make([]Sink, 0, len(old)+1), old...), s)
+	pI.sinks.Store(&next)
+}
+
+// emit forwards an update to the sinks added with AddSink.
+func (pI *Registry) emit(pType Type, name string, kv map[string]string, value float64, add bool) {
+	for _, s := range *pI.sinks.Load() {
+		switch pType {
+		case TypeQPS:
+			s.Count(name, kv, value)
+		case TypeTotal, TypeSummary:
+			s.Observe(name, kv, value)
+		case TypeGauge:
+			s.Gauge(name, kv, value, add)
+		}
+	}
+}
+
+// emit is Registry.emit for positional label values. The label map is
+// only built when there are sinks.
+func (h *Handle) emit(pType Type, value float64, values []string, add bool) {
+	if len(*h.reg.sinks.Load()) == 0 {
+		return
+	}
+	kv := make(map[string]string, len(values))
+	for i, l := range h.m.labels {
+		if i < len(values) {
+			kv[l] = values[i]
+		}
+	}
+	h.reg.emit(pType, h.name, kv, value, add)
+}
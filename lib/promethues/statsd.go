@@ -0,0 +1,256 @@
+package prometheus
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdErrorsName counts packets the StatsD sink failed to send.
+const statsdErrorsName = "statsd_send_errors_total"
+
+// StatsDConf is the [metrics.statsd] section, used when Conf.Sinks lists
+// "statsd".
+type StatsDConf struct {
+	// Addr is the agent's UDP address, "127.0.0.1:8125" by default.
+	Addr string `toml:"addr"`
+	// Prefix defaults to "<namespace>.<subsystem>.".
+	Prefix string `toml:"prefix"`
+	// Flavor "dogstatsd" (the default) sends observations as histograms,
+	// "statsd" as timers. Both tag lines in the DogStatsD "|#k:v" format
+	// with the labels and the const labels of the Registry.
+	Flavor string `toml:"flavor"`
+	// SampleRate below 1 sends only that share of counts and
+	// observations, gauges are always sent.
+	SampleRate float64 `toml:"sample_rate"`
+	// Lines are batched into packets of at most MaxPacketSize bytes, sent
+	// when full or every FlushInterval.
+	MaxPacketSize int    `toml:"max_packet_size"`
+	FlushInterval string `toml:"flush_interval"`
+}
+
+// StatsDSink is a Sink sending batched lines to a StatsD agent over UDP.
+type StatsDSink struct {
+	reg        *Registry
+	conn       net.Conn
+	prefix     string
+	tags       string
+	timer      string
+	sampleRate float64
+	maxPacket  int
+	interval   time.Duration
+
+	mu       sync.Mutex
+	buf      []byte
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewStatsDSink connects a StatsD sink for the registry and starts its
+// flushes. Add it with AddSink and Close it on shutdown.
+func (pI *Registry) NewStatsDSink(conf StatsDConf) (*StatsDSink, error) {
+	addr := conf.Addr
+	if addr == "" {
+		addr = "127.0.0.1:8125"
+	}
+	interval, err := parseDuration(conf.FlushInterval, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	timer := "h"
+	switch conf.Flavor {
+	case "", "dogstatsd":
+	case "statsd":
+		timer = "ms"
+	default:
+		return nil, errors.New("statsd flavor must be dogstatsd or statsd")
+	}
+	sampleRate := conf.SampleRate
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+	maxPacket := conf.MaxPacketSize
+	if maxPacket <= 0 {
+		// Fits an Ethernet MTU with IP and UDP headers.
+		maxPacket = 1432
+	}
+	prefix := conf.Prefix
+	if prefix == "" {
+		prefix = pI.namespace + "." + pI.subsystem + "."
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pI.registeQps(statsdErrorsName, "packets the statsd sink failed to send", nil)
+	s := &StatsDSink{
+		reg:        pI,
+		conn:       conn,
+		prefix:     prefix,
+		tags:       formatTags(pI.constLabels),
+		timer:      timer,
+		sampleRate: sampleRate,
+		maxPacket:  maxPacket,
+		interval:   interval,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *StatsDSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *StatsDSink) Count(name string, labels map[string]string, value float64) {
+	if s.sampled() {
+		s.write(name, labels, formatValue(value), "c", true)
+	}
+}
+
+// Gauge sets or moves a gauge. StatsD reads a signed value as a delta, so
+// a negative value is set by resetting the gauge to 0 first.
+func (s *StatsDSink) Gauge(name string, labels map[string]string, value float64, add bool) {
+	v := formatValue(value)
+	switch {
+	case add && value >= 0:
+		v = "+" + v
+	case !add && value < 0:
+		s.write(name, labels, "0", "g", false)
+	}
+	s.write(name, labels, v, "g", false)
+}
+
+func (s *StatsDSink) Observe(name string, labels map[string]string, value float64) {
+	if s.sampled() {
+		s.write(name, labels, formatValue(value), s.timer, true)
+	}
+}
+
+func (s *StatsDSink) sampled() bool {
+	return s.sampleRate >= 1 || rand.Float64() < s.sampleRate
+}
+
+func (s *StatsDSink) write(name string, labels map[string]string, value string, kind string, sampled bool) {
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(sanitizeStatsD(name))
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(kind)
+	if sampled && s.sampleRate < 1 {
+		b.WriteString("|@")
+		b.WriteString(strconv.FormatFloat(s.sampleRate, 'f', -1, 64))
+	}
+	tags := formatTags(labels)
+	if tags != "" || s.tags != "" {
+		b.WriteString("|#")
+		b.WriteString(s.tags)
+		if tags != "" && s.tags != "" {
+			b.WriteByte(',')
+		}
+		b.WriteString(tags)
+	}
+	line := b.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > s.maxPacket {
+		s.flushLocked()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+	if len(s.buf) >= s.maxPacket {
+		s.flushLocked()
+	}
+}
+
+// Flush sends the batched lines now.
+func (s *StatsDSink) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+func (s *StatsDSink) flushLocked() {
+	if len(s.buf) == 0 {
+		return
+	}
+	if _, err := s.conn.Write(s.buf); err != nil {
+		// Counted on the Prometheus side only, never fanned out again.
+		if m, ok := s.reg.lookup(statsdErrorsName); ok {
+			_ = m.addQps(map[string]string{}, 1)
+		}
+	}
+	s.buf = s.buf[:0]
+}
+
+// Close stops the periodic flushes, sends what is left and closes the
+// connection. It matches recycle.ResourceRecyclable.
+func (s *StatsDSink) Close() bool {
+	closed := false
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		s.Flush()
+		closed = s.conn.Close() == nil
+	})
+	return closed
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// formatTags renders labels as sorted DogStatsD tags, "k1:v1,k2:v2".
+func formatTags(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(sanitizeStatsD(k))
+		b.WriteByte(':')
+		b.WriteString(tagValueReplacer.Replace(labels[k]))
+	}
+	return b.String()
+}
+
+var (
+	statsdReplacer   = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", "\n", "_")
+	tagValueReplacer = strings.NewReplacer("|", "_", ",", "_", "\n", "_")
+)
+
+// sanitizeStatsD replaces the separators of the line format in names and
+// tag keys. Tag values may keep colons, e.g. "/image/:id".
+func sanitizeStatsD(s string) string {
+	return statsdReplacer.Replace(s)
+}
@@ -0,0 +1,116 @@
+package prometheus
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// statsdAgent listens like a StatsD agent and returns the lines of the
+// next packet.
+func statsdAgent(t *testing.T) (string, func() []string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String(), func() []string {
+		buf := make([]byte, 65536)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("read statsd packet: %v", err)
+		}
+		return strings.Split(string(buf[:n]), "\n")
+	}
+}
+
+func newStatsDRegistry(t *testing.T, conf StatsDConf) (*Registry, *StatsDSink) {
+	reg, err := NewRegistry("xx_server", "test", Conf{
+		Labels:     map[string]string{"cluster": "c1"},
+		TargetInfo: true,
+		Sinks:      []string{SinkPrometheus, SinkStatsD},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reg.Close() })
+	conf.FlushInterval = "1h"
+	sink, err := reg.NewStatsDSink(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	reg.AddSink(sink)
+	reg.Registe(TypeQPS, "requests", []string{"route", "status"}, nil)
+	reg.Registe(TypeTotal, "latency", []string{"route"}, nil)
+	reg.Registe(TypeGauge, "connections", []string{"pool"}, nil)
+	return reg, sink
+}
+
+func TestStatsDLines(t *testing.T) {
+	tests := []struct {
+		flavor string
+		timer  string
+	}{
+		{"dogstatsd", "h"},
+		{"statsd", "ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flavor, func(t *testing.T) {
+			addr, read := statsdAgent(t)
+			reg, sink := newStatsDRegistry(t, StatsDConf{Addr: addr, Flavor: tt.flavor})
+			updates := []func() error{
+				func() error {
+					return reg.Update(TypeQPS, "requests", map[string]string{"route": "/image/:id", "status": "200"}, 2)
+				},
+				func() error { return reg.Update(TypeTotal, "latency", map[string]string{"route": "/a|b,c"}, 12.5) },
+				func() error { return reg.Update(TypeGauge, "connections", map[string]string{"pool": "db"}, 7) },
+				func() error { return reg.Add(TypeGauge, "connections", map[string]string{"pool": "db"}, 3) },
+				func() error { return reg.Add(TypeGauge, "connections", map[string]string{"pool": "db"}, -1) },
+				func() error { return reg.Update(TypeGauge, "connections", map[string]string{"pool": "db"}, -4) },
+			}
+			for _, u := range updates {
+				if err := u(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sink.Flush()
+			want := []string{
+				"dispatcher.xx_server.requests:2|c|#cluster:c1,route:/image/:id,status:200",
+				"dispatcher.xx_server.latency:12.5|" + tt.timer + "|#cluster:c1,route:/a_b_c",
+				"dispatcher.xx_server.connections:7|g|#cluster:c1,pool:db",
+				"dispatcher.xx_server.connections:+3|g|#cluster:c1,pool:db",
+				"dispatcher.xx_server.connections:-1|g|#cluster:c1,pool:db",
+				"dispatcher.xx_server.connections:0|g|#cluster:c1,pool:db",
+				"dispatcher.xx_server.connections:-4|g|#cluster:c1,pool:db",
+			}
+			got := read()
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("got lines\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
+
+func TestStatsDPacketSize(t *testing.T) {
+	addr, read := statsdAgent(t)
+	reg, sink := newStatsDRegistry(t, StatsDConf{Addr: addr, Prefix: "p.", MaxPacketSize: 64})
+	for i := 0; i < 3; i++ {
+		if err := reg.Inc(TypeQPS, "requests", map[string]string{"route": "/", "status": "200"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Flush()
+	for _, packet := range [][]string{read(), read(), read()} {
+		if size := len(strings.Join(packet, "\n")); size > 64 {
+			t.Errorf("packet of %d bytes, want at most 64", size)
+		}
+		for _, line := range packet {
+			if line != "p.requests:1|c|#cluster:c1,route:/,status:200" {
+				t.Errorf("line %q", line)
+			}
+		}
+	}
+}
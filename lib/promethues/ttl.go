@@ -0,0 +1,125 @@
+package prometheus
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// expiredMetricName counts series deleted by their metric's TTL.
+const expiredMetricName = "series_expired_total"
+
+// expiryTick is how often metrics with a TTL are swept.
+const expiryTick = 10 * time.Second
+
+// seriesTracker remembers when each label set of a metric was last
+// updated, so series of gone dependencies or tables can be deleted.
+type seriesTracker struct {
+	ttl    time.Duration
+	series sync.Map
+	// mutex is read-locked by updates from admission to touch, and locked
+	// by expire, so a series is never deleted between the two.
+	mutex sync.RWMutex
+}
+
+type trackedSeries struct {
+	values []string
+	last   atomic.Int64
+}
+
+// newSeriesTracker returns nil for metrics without a TTL. ttl is checked
+// by Conf.Validate.
+func newSeriesTracker(ttl string) *seriesTracker {
+	if ttl == "" {
+		return nil
+	}
+	d, _ := time.ParseDuration(ttl)
+	return &seriesTracker{ttl: d}
+}
+
+// touch records an update of the label set key. values is only called
+// the first time key is seen.
+func (t *seriesTracker) touch(key string, values func() []string) {
+	now := time.Now().UnixNano()
+	if s, ok := t.series.Load(key); ok {
+		s.(*trackedSeries).last.Store(now)
+		return
+	}
+	s := &trackedSeries{values: values()}
+	s.last.Store(now)
+	if old, loaded := t.series.LoadOrStore(key, s); loaded {
+		old.(*trackedSeries).last.Store(now)
+	}
+}
+
+// expire deletes the series of m not updated within the TTL and frees
+// their slots in the series limit. An update after the deletion starts
+// the series over from zero.
+func (m *metric) expire(now time.Time) int {
+	cutoff := now.Add(-m.ttl.ttl).UnixNano()
+	expired := 0
+	m.ttl.series.Range(func(k, v interface{}) bool {
+		s := v.(*trackedSeries)
+		if s.last.Load() >= cutoff {
+			return true
+		}
+		m.ttl.mutex.Lock()
+		defer m.ttl.mutex.Unlock()
+		// An update may have touched it since it was read above.
+		if s.last.Load() >= cutoff {
+			return true
+		}
+		m.ttl.series.Delete(k)
+		m.deleteSeries(s.values)
+		m.guard.forget(k.(string), k.(string) == m.overflowKey())
+		expired++
+		return true
+	})
+	return expired
+}
+
+func (m *metric) deleteSeries(values []string) {
+	if m.qps != nil {
+		m.qps.DeleteLabelValues(values...)
+	}
+	if m.total != nil {
+		m.total.DeleteLabelValues(values...)
+	}
+	if m.summary != nil {
+		m.summary.DeleteLabelValues(values...)
+	}
+	if m.gauge != nil {
+		m.gauge.DeleteLabelValues(values...)
+	}
+}
+
+// startExpiry sweeps metrics with a TTL until Close. It runs at most once
+// per Registry.
+func (pI *Registry) startExpiry() {
+	pI.expiryOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(expiryTick)
+			defer ticker.Stop()
+			for {
+				select {
+				case now := <-ticker.C:
+					pI.expire(now)
+				case <-pI.closed:
+					return
+				}
+			}
+		}()
+	})
+}
+
+func (pI *Registry) expire(now time.Time) {
+	expiredCounter, _ := pI.lookup(expiredMetricName)
+	for name, m := range *pI.metrics.Load() {
+		if m.ttl == nil {
+			continue
+		}
+		if n := m.expire(now); n > 0 && expiredCounter != nil {
+			_ = expiredCounter.addQps(map[string]string{"metric": name}, float64(n))
+		}
+	}
+}
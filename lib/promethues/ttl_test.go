@@ -0,0 +1,59 @@
+package prometheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestExpireFreesSeries(t *testing.T) {
+	reg, err := NewRegistry("test", "test", Conf{
+		MaxSeries: 1,
+		Metric:    map[string]MetricConf{"requests": {TTL: "10ms"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Close()
+	reg.Registe(TypeQPS, "requests", []string{"method"}, nil)
+	m, _ := reg.lookup("requests")
+	for _, method := range []string{"/a", "/b"} {
+		if err := reg.Update(TypeQPS, "requests", map[string]string{"method": method}, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := testutil.ToFloat64(m.qps.WithLabelValues(OverflowValue)); v != 1 {
+		t.Fatalf("overflow series = %v, want 1", v)
+	}
+
+	// Not yet expired.
+	reg.expire(time.Now())
+	if n := testutil.CollectAndCount(m.qps); n != 2 {
+		t.Fatalf("%d series before the TTL, want 2", n)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	reg.expire(time.Now())
+	if n := testutil.CollectAndCount(m.qps); n != 0 {
+		t.Errorf("%d series after the TTL, want 0", n)
+	}
+	if n := m.guard.count.Load(); n != 0 {
+		t.Errorf("%d slots taken after the TTL, want 0", n)
+	}
+	expired, _ := reg.lookup(expiredMetricName)
+	if v := testutil.ToFloat64(expired.qps.WithLabelValues("requests")); v != 2 {
+		t.Errorf("%s = %v, want 2", expiredMetricName, v)
+	}
+
+	// The freed slot admits a label set that was folded before.
+	if err := reg.Update(TypeQPS, "requests", map[string]string{"method": "/b"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(m.qps); n != 1 {
+		t.Errorf("%d series after re-admission, want 1", n)
+	}
+	if v := testutil.ToFloat64(m.qps.WithLabelValues("/b")); v != 1 {
+		t.Errorf("re-admitted series = %v, want 1", v)
+	}
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"context"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -21,10 +22,65 @@ func SetReqId() gin.HandlerFunc {
 			}
 		}
 		c.Set("request_id", requestId)
+		if traceId := parseTraceId(c); traceId != "" {
+			c.Set("trace_id", traceId)
+		}
+		if route := c.FullPath(); route != "" {
+			c.Set("route", route)
+		}
+		c.Set("client_ip", ClientIP(c))
 		c.Next()
 	}
 }
 
+// ClientIP returns the first X-Forwarded-For address, X-Real-Ip, or the
+// address of the peer.
+func ClientIP(c *gin.Context) string {
+	ip := c.GetHeader("X-Forwarded-For")
+	if index := strings.IndexByte(ip, ','); index >= 0 {
+		ip = ip[0:index]
+	}
+	ip = strings.TrimSpace(ip)
+	if len(ip) > 0 {
+		return ip
+	}
+	ip = strings.TrimSpace(c.GetHeader("X-Real-Ip"))
+	if len(ip) > 0 {
+		return ip
+	}
+	return c.RemoteIP()
+}
+
+// parseTraceId reads the trace id from a W3C traceparent header,
+// falling back to X-TRACE-ID. Only a 32 hex digit id is taken, it ends up
+// in metric exemplars, whose size is limited.
+func parseTraceId(c *gin.Context) string {
+	if tp := c.GetHeader("traceparent"); tp != "" {
+		parts := strings.Split(tp, "-")
+		if len(parts) == 4 && isTraceId(parts[1]) {
+			return parts[1]
+		}
+	}
+	if traceId := c.GetHeader("X-TRACE-ID"); isTraceId(traceId) {
+		return traceId
+	}
+	return ""
+}
+
+// isTraceId reports whether s is a W3C trace id, 32 hex digits.
+func isTraceId(s string) bool {
+	if len(s) != 32 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
 func GetRequestId(c context.Context) string {
 	if c == nil {
 		return "null_request_id"
@@ -36,3 +92,31 @@ func GetRequestId(c context.Context) string {
 	}
 	return "null_request_id"
 }
+
+// GetTraceId returns the trace id of the request, or "" when there is none.
+func GetTraceId(c context.Context) string {
+	if c == nil {
+		return ""
+	}
+	traceId, _ := c.Value("trace_id").(string)
+	return traceId
+}
+
+// GetRoute returns the route template of the request, e.g. "/image/:id",
+// or "" when no route matched.
+func GetRoute(c context.Context) string {
+	if c == nil {
+		return ""
+	}
+	route, _ := c.Value("route").(string)
+	return route
+}
+
+// GetClientIP returns the client address set by SetReqId, or "".
+func GetClientIP(c context.Context) string {
+	if c == nil {
+		return ""
+	}
+	ip, _ := c.Value("client_ip").(string)
+	return ip
+}
@@ -12,6 +12,7 @@ import (
 	"prometheus-test/infrastructure/http_client/trace_http"
 	"prometheus-test/infrastructure/metrics"
 	"prometheus-test/infrastructure/recycle"
+	"prometheus-test/lib/gomonitor"
 	"prometheus-test/lib/logger"
 	"prometheus-test/server/httpserver"
 	"runtime/debug"
@@ -28,13 +29,22 @@ const (
 var (
 	Ctx      context.Context
 	confPath = flag.String("c", "./conf/common.dev.toml", "config path")
+	genDir   = flag.String("gen-monitoring", "", "write prometheus rules and a grafana dashboard of the metrics to this dir and exit")
+	genCheck = flag.Bool("gen-check", false, "with -gen-monitoring, only check that the files in the dir are up to date")
 )
 
 func main() {
 	defer func() {
 		if r := recover(); r != nil {
-			DoubleOutput(Fatal, "[DS]PanicError panic",
-				"panic", r, "trace", string(debug.Stack()))
+			stack := debug.Stack()
+			if reporter := gomonitor.DefaultCrashReporter(); reporter != nil {
+				if path, err := reporter.Report(gomonitor.CrashPanic, r, stack, ""); err != nil {
+					log.Printf("[DS]Write crash report failed,err=%v", err)
+				} else {
+					log.Printf("[DS]Crash report written,path=%s", path)
+				}
+			}
+			DoubleOutput(Fatal, "[DS]PanicError panic=%v,trace=%s", r, stack)
 		}
 	}()
 	flag.Parse()
@@ -44,16 +54,28 @@ func main() {
 	if err != nil {
 		log.Fatalf("[DS]InitConfig failed,err=%v", err)
 	}
-	err = setCrashLog(config.Cfg.CommonConf.CrashLogPath)
+	if *genDir != "" {
+		if err := metrics.Generate(*genDir, config.Cfg.CommonConf.ServerName, config.Cfg.MetricsConf(), *genCheck); err != nil {
+			log.Fatalf("[DS]Generate monitoring failed,err=%v", err)
+		}
+		return
+	}
+	reporter, err := gomonitor.InitCrashReporter(config.Cfg.Crash, config.Cfg.CommonConf.CrashLogPath, config.Hash)
 	if err != nil {
-		log.Fatalf("[DS]setCrashLog failed,err=%v", err)
+		log.Fatalf("[DS]InitCrashReporter failed,err=%v", err)
 	}
 	if logger.Init(config.Cfg.Log) != nil {
 		return
 	}
 	defer logger.Close()
+	recycle.RegisterRecycles(logger.CloseAccess)
+	recycle.RegisterRecycles(reporter.Stop)
+	TuneRuntime()
 	trace_http.Init()
 	InitMetrics()
+	if InitProfiler() {
+		return
+	}
 	if InitMysql() {
 		return
 	}
@@ -85,12 +107,33 @@ func InitMysql() bool {
 }
 
 func InitMetrics() {
-	if err := metrics.Init(config.Cfg.CommonConf.ServerName); err != nil {
+	if err := metrics.Init(config.Cfg.CommonConf.ServerName, config.Cfg.MetricsConf()); err != nil {
 		DoubleOutput(Fatal, "[DS]Init monitor  failed ,err=%v", err)
 	}
 	DoubleOutput(Info, "[DS]Init metrics success!")
 }
 
+func TuneRuntime() {
+	t := gomonitor.Tune(config.Cfg.Runtime)
+	DoubleOutput(Info, "[DS]Tune runtime,cgroup=v%d,cpu_quota=%g,memory_limit=%d,GOMAXPROCS=%d,GOMEMLIMIT=%d",
+		t.CgroupVersion, t.CPUQuota, t.MemoryLimit, t.GoMaxProcs, t.GoMemLimit)
+}
+
+func InitProfiler() bool {
+	if !config.Cfg.Profile.Enable {
+		return false
+	}
+	profiler, err := gomonitor.InitProfiler(config.Cfg.Profile)
+	if err != nil {
+		DoubleOutput(Fatal, "[DS]Init profiler failed,err=%v", err)
+		return true
+	}
+	profiler.Start()
+	recycle.RegisterRecycles(profiler.Stop)
+	DoubleOutput(Info, "[DS]Init profiler success!")
+	return false
+}
+
 func signalHandler() context.Context {
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -105,46 +148,14 @@ func signalHandler() context.Context {
 	return ctx
 }
 
-func setCrashLog(file string) error {
-	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		return err
-	}
-	return syscall.Dup2(int(f.Fd()), 2)
-}
-
 func DoubleOutput(level Level, msg string, args ...any) {
-	if args != nil {
-		log.Printf(msg, args)
-	} else {
-		log.Printf(msg)
-	}
+	log.Printf(msg, args...)
 	switch level {
 	case Info:
-		{
-			if args != nil {
-				logger.NotCtxInfof(msg, args)
-			} else {
-				logger.NotCtxInfof(msg)
-			}
-			return
-		}
+		logger.NotCtxInfof(msg, args...)
 	case Fatal:
-		{
-			if args != nil {
-				logger.NotCtxFatalf(msg, args)
-			} else {
-				logger.NotCtxFatalf(msg)
-			}
-			return
-		}
+		logger.NotCtxFatalf(msg, args...)
 	default:
-		{
-			if args != nil {
-				logger.NotCtxInfof(msg, args)
-			} else {
-				logger.NotCtxInfof(msg)
-			}
-		}
+		logger.NotCtxInfof(msg, args...)
 	}
 }
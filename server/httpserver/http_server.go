@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"net/http"
 	"prometheus-test/infrastructure/config"
+	"prometheus-test/lib/gomonitor"
 	middleware2 "prometheus-test/server/httpserver/middleware"
 	"time"
 
 	"prometheus-test/lib/logger"
+	prometheus "prometheus-test/lib/promethues"
 	"prometheus-test/lib/util"
 
 	"github.com/facebookgo/grace/gracehttp"
@@ -15,6 +17,9 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// debugPrefix hides the debug endpoints from scanners.
+const debugPrefix = "/qnk8avm9pa/debug"
+
 type HttpServer struct {
 	Port         int
 	ReadTimeout  int
@@ -22,7 +27,7 @@ type HttpServer struct {
 	HttpSvr      *http.Server
 }
 
-func newHttpGinServer(port int, rTimeout int, wTimeout int) *HttpServer {
+func newHttpGinServer(port int, rTimeout int, wTimeout int, registry *prometheus.Registry) *HttpServer {
 	server := &HttpServer{
 		Port:         port,
 		ReadTimeout:  rTimeout,
@@ -33,11 +38,20 @@ func newHttpGinServer(port int, rTimeout int, wTimeout int) *HttpServer {
 	engine := gin.New()
 	engine.Use(
 		util.SetReqId(),
-		middleware2.GinLogger())
+		middleware2.DebugLog(),
+		middleware2.GinLogger(),
+		// Outside Recovery, so requests that panicked are counted with
+		// their 500.
+		middleware2.MonitorHandler(),
+		middleware2.Recovery())
 
-	registerMetrics(engine)
+	registerMetrics(engine, registry)
 	registerHealthDetect(engine)
-	pprof.Register(engine, "/qnk8avm9pa/debug/pprof")
+	pprof.Register(engine, debugPrefix+"/pprof")
+	registerLogLevel(engine)
+	if profiler := gomonitor.DefaultProfiler(); profiler != nil {
+		registerProfiles(engine, profiler)
+	}
 
 	registerCommonBizRouters(engine)
 
@@ -70,15 +84,93 @@ func registerCommonBizRouters(engine *gin.Engine) {
 	}
 }
 
-func registerMetrics(engine *gin.Engine) {
-	engine.Use(middleware2.MonitorHandler())
+func registerMetrics(engine *gin.Engine, registry *prometheus.Registry) {
+	metricsHandler := registry.NewHttpHander()
+	engine.GET("/metrics", func(c *gin.Context) {
+		metricsHandler.ServeHTTP(c.Writer, c.Request)
+	})
+}
+
+// requireDebugToken refuses requests without the debug token in the
+// debug header, and all requests when no token is configured.
+func requireDebugToken(what string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if header := logger.DebugHeader(); header == "" || !logger.TrustedDebug(c.GetHeader(header)) {
+			c.String(http.StatusForbidden, what+" need the debug token")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// registerProfiles lists the captured profiles and serves their files,
+// e.g. for "go tool pprof http://host/<debugPrefix>/profiles/<capture>/heap.pprof".
+// Both take the debug token, profiles expose memory contents.
+func registerProfiles(engine *gin.Engine, profiler *gomonitor.Profiler) {
+	profiles := engine.Group(debugPrefix+"/profiles", requireDebugToken("profiles"))
+	profiles.GET("", func(c *gin.Context) {
+		captures, err := profiler.List()
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, captures)
+	})
+	profiles.GET("/:capture/:file", func(c *gin.Context) {
+		path, err := profiler.Path(c.Param("capture"), c.Param("file"))
+		if err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		c.FileAttachment(path, c.Param("capture")+"-"+c.Param("file"))
+	})
+}
+
+// Level changes revert after defaultLevelRevert unless a revert of at
+// most maxLevelRevert is given. revert=0 keeps the level until the next
+// change.
+const (
+	defaultLevelRevert = 30 * time.Minute
+	maxLevelRevert     = 24 * time.Hour
+)
+
+// registerLogLevel reads and changes the business log level, e.g.
+// "PUT <debugPrefix>/log/level?level=debug&revert=10m" logs at debug for
+// ten minutes. Changing it takes the debug token in the debug header, and
+// is off without a token.
+func registerLogLevel(engine *gin.Engine) {
+	engine.GET(debugPrefix+"/log/level", func(c *gin.Context) {
+		c.JSON(http.StatusOK, logger.GetLevel())
+	})
+	engine.PUT(debugPrefix+"/log/level", requireDebugToken("log level changes"), func(c *gin.Context) {
+		revert := defaultLevelRevert
+		if s := c.Query("revert"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			revert = d
+		}
+		if revert < 0 || revert > maxLevelRevert {
+			c.String(http.StatusBadRequest, "revert must be 0 (never) or at most "+maxLevelRevert.String())
+			return
+		}
+		if err := logger.SetLevel(c.Query("level"), revert); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		logger.Warn(c, "log level changed", "level", c.Query("level"), "revert", revert.String())
+		c.JSON(http.StatusOK, logger.GetLevel())
+	})
 }
 
 func Start() error {
 	httpConf := config.Cfg.ServerConf
 	logger.NotCtxInfo("Start http server", "gport", httpConf.GPort)
 	ginServer := newHttpGinServer(httpConf.GPort,
-		httpConf.RTimeout, httpConf.WTimeout)
+		httpConf.RTimeout, httpConf.WTimeout, prometheus.Default())
 	err := gracehttp.Serve(ginServer.HttpSvr)
 	return err
 }
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"prometheus-test/lib/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DebugLog logs the lines of a request at debug level when it carries
+// the trusted logger.DebugHeader.
+func DebugLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if header := logger.DebugHeader(); header != "" && logger.TrustedDebug(c.GetHeader(header)) {
+			c.Set(logger.DebugContextKey, true)
+		}
+		c.Next()
+	}
+}
@@ -2,7 +2,6 @@ package middleware
 
 import (
 	"fmt"
-	"strings"
 	"time"
 
 	"prometheus-test/lib/logger"
@@ -19,9 +18,9 @@ func GinLogger() gin.HandlerFunc {
 
 		cost := time.Since(start)
 
-		logger.AccessInfo(c, fmt.Sprintf("%s|%s|%.3f|%s|%s|%s|%d|%s|%s|%d|%s|%s|%s|%s|%s|%s|%s|\n",
+		logger.AccessInfo(c, fmt.Sprintf("%s|%s|%.3f|%s|%s|%s|%d|%s|%s|%d|%s|%s|%s|%s|%s|%s|%s|",
 			start.Format("2006-01-02T15:04:05.000Z07:00"),
-			clientIP(c),
+			util.ClientIP(c),
 			cost.Seconds(),
 			"-",
 			"-",
@@ -40,19 +39,3 @@ func GinLogger() gin.HandlerFunc {
 		))
 	}
 }
-
-func clientIP(ctx *gin.Context) string {
-	ip := ctx.GetHeader("X-Forwarded-For")
-	if index := strings.IndexByte(ip, ','); index >= 0 {
-		ip = ip[0:index]
-	}
-	ip = strings.TrimSpace(ip)
-	if len(ip) > 0 {
-		return ip
-	}
-	ip = strings.TrimSpace(ctx.GetHeader("X-Real-Ip"))
-	if len(ip) > 0 {
-		return ip
-	}
-	return ctx.RemoteIP()
-}
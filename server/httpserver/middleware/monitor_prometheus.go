@@ -7,16 +7,23 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// UnmatchedRoute labels requests that hit no route, so scanners probing
+// random URLs add one series instead of one per URL.
+const UnmatchedRoute = "__unmatched__"
+
 func MonitorHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
-		path := c.Request.URL.Path
+		path := c.FullPath()
+		if path == "" {
+			path = UnmatchedRoute
+		}
 
 		c.Next()
 		interval := time.Since(start).Milliseconds()
 		statusCode := c.Writer.Status()
 
 		metrics.UpdateInterfaceQPS(path, statusCode, 1)
-		metrics.UpdateInterface(path, statusCode, interval)
+		metrics.UpdateInterface(c, path, statusCode, interval)
 	}
 }
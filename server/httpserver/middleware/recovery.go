@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"prometheus-test/lib/gomonitor"
+	"prometheus-test/lib/logger"
+	"prometheus-test/lib/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovery answers 500 to a panicking request and writes a crash report
+// of it, or logs its stack when the reporter skips it. It also tracks the request ids for the reports.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reporter := gomonitor.DefaultCrashReporter()
+		if reporter != nil {
+			reporter.Track(util.GetRequestId(c))
+		}
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+			stack := debug.Stack()
+			var path string
+			var err error
+			if reporter != nil {
+				path, err = reporter.Report(gomonitor.CrashRecovered, r, stack, util.GetRequestId(c))
+			}
+			// Without a report, e.g. rate limited, the stack goes to the log.
+			if path != "" {
+				logger.Error(c, "panic recovered", "panic", r, "report", path)
+			} else {
+				logger.Error(c, "panic recovered", "panic", r, "stack", string(stack), "err", err)
+			}
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}()
+		c.Next()
+	}
+}
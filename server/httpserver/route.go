@@ -5,7 +5,6 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"github.com/gin-gonic/gin"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gorm.io/gorm"
 	"image"
 	"image/color"
@@ -44,13 +43,6 @@ func registerGinHttpAction(path string, method int, handlers ...gin.HandlerFunc)
 }
 
 func init() {
-	registerGinHttpAction(
-		"/metrics",
-		MethodGET,
-		func(ctx *gin.Context) {
-			promhttp.Handler().ServeHTTP(ctx.Writer, ctx.Request)
-		})
-
 	registerGinHttpAction(
 		"/GenerateImagesUsingText",
 		MethodPOST,